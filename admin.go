@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminActorKey 认证通过后记录在请求上下文中的管理员名称，用于审计
+const adminActorKey = "admin_actor"
+
+// adminTokens 返回管理令牌名称到令牌的映射，admin_token 对应名称 admin
+func adminTokens() map[string]string {
+	tokens := make(map[string]string)
+	if token := os.ExpandEnv(env.AdminToken); token != "" {
+		tokens["admin"] = token
+	}
+	for name, token := range env.AdminTokens {
+		if token = os.ExpandEnv(token); token != "" {
+			tokens[name] = token
+		}
+	}
+	return tokens
+}
+
+// requireAdmin 管理接口认证
+// 配置了admin_token或admin_tokens时要求请求携带其中一个令牌；都未配置时管理接口关闭，
+// 除非显式设置admin_allow_localhost允许本机免认证访问(同机的反向代理转发的请求也会被视为本机)
+func requireAdmin(c *gin.Context) {
+	tokens := adminTokens()
+	if len(tokens) == 0 {
+		if !env.AdminAllowLocalhost {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API is disabled; set admin_token or admin_tokens to enable it"})
+			return
+		}
+		host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil || !net.ParseIP(host).IsLoopback() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API without admin_token is only available from localhost"})
+			return
+		}
+		c.Set(adminActorKey, "localhost")
+		c.Next()
+		return
+	}
+
+	token := c.GetHeader("X-Api-Key")
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		token = strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	actor := ""
+	for name, adminToken := range tokens {
+		// 比较所有令牌，耗时不随匹配位置变化
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+			actor = name
+		}
+	}
+	if actor == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+		return
+	}
+	c.Set(adminActorKey, actor)
+	c.Next()
+}
+
+// registerAdminRoutes 注册管理接口；/debug/route会暴露上游和规则配置，同样需要管理认证
+func registerAdminRoutes(r *gin.Engine) {
+	r.GET("/debug/route", requireAdmin, handleDebugRoute)
+	admin := r.Group("/admin", requireAdmin)
+	admin.GET("/breakers", handleAdminBreakers)
+	admin.POST("/breakers/reset", handleAdminResetBreakers)
+	admin.GET("/keys", handleAdminListKeys)
+	admin.POST("/keys", handleAdminCreateKey)
+	admin.GET("/keys/:id", handleAdminGetKey)
+	admin.PATCH("/keys/:id", handleAdminUpdateKey)
+	admin.POST("/keys/:id/revoke", handleAdminRevokeKey)
+	admin.GET("/upstreams", handleAdminListUpstreams)
+	admin.PUT("/upstreams/:name", handleAdminPutUpstream)
+	admin.DELETE("/upstreams/:name", handleAdminDeleteUpstream)
+	admin.GET("/model-rules", handleAdminGetModelRules)
+	admin.PUT("/model-rules", handleAdminPutModelRules)
+	admin.GET("/usage", handleAdminUsage)
+	admin.GET("/audit", handleAdminAudit)
+}
+
+// handleAdminBreakers 返回所有熔断器状态
+func handleAdminBreakers(c *gin.Context) {
+	statuses := breakerStatuses()
+	if statuses == nil {
+		statuses = []BreakerStatus{}
+	}
+	c.JSON(http.StatusOK, gin.H{"breakers": statuses})
+}
+
+// handleAdminResetBreakers 手动关闭熔断器，可按upstream和model过滤
+func handleAdminResetBreakers(c *gin.Context) {
+	upstreamName := c.Query("upstream")
+	model := c.Query("model")
+
+	reset := 0
+	routingMu.RLock()
+	defer routingMu.RUnlock()
+	for name, upstream := range upstreams {
+		if upstreamName != "" && name != upstreamName {
+			continue
+		}
+		upstream.breakersMu.Lock()
+		for breakerModel, breaker := range upstream.breakers {
+			if model != "" && breakerModel != model {
+				continue
+			}
+			breaker.Reset()
+			reset++
+		}
+		upstream.breakersMu.Unlock()
+	}
+	c.JSON(http.StatusOK, gin.H{"reset": reset})
+}
+
+// requireVirtualKeys 检查虚拟密钥是否已启用
+func requireVirtualKeys(c *gin.Context) bool {
+	if virtualKeys == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Virtual keys are not enabled"})
+		return false
+	}
+	return true
+}
+
+// handleAdminListKeys 列出虚拟密钥，不返回密钥哈希和真实上游密钥
+func handleAdminListKeys(c *gin.Context) {
+	if !requireVirtualKeys(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": virtualKeys.List()})
+}
+
+// handleAdminCreateKey 签发虚拟密钥，明文密钥只在响应中出现一次
+func handleAdminCreateKey(c *gin.Context) {
+	if !requireVirtualKeys(c) {
+		return
+	}
+	var request struct {
+		Name        string            `json:"name"`
+		Owner       string            `json:"owner"`
+		Team        string            `json:"team"`
+		Credentials map[string]string `json:"credentials"`
+		ExpiresAt   *time.Time        `json:"expires_at"`
+		ExpiresIn   int               `json:"expires_in_days"`
+		RateLimit   *RateLimitConfig  `json:"rate_limit"`
+		Budget      *BudgetConfig     `json:"budget"`
+		Policy      *KeyPolicy        `json:"policy"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	key := VirtualKey{
+		Name:        request.Name,
+		Owner:       request.Owner,
+		Team:        request.Team,
+		Credentials: request.Credentials,
+		ExpiresAt:   request.ExpiresAt,
+		RateLimit:   request.RateLimit,
+		Budget:      request.Budget,
+		Policy:      request.Policy,
+	}
+	if request.ExpiresIn > 0 {
+		expiresAt := time.Now().UTC().AddDate(0, 0, request.ExpiresIn)
+		key.ExpiresAt = &expiresAt
+	}
+
+	created, secret, err := virtualKeys.Create(key)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "key.create", created.ID, nil, created.View())
+	c.JSON(http.StatusCreated, gin.H{"key": secret, "virtual_key": created.View()})
+}
+
+// handleAdminRevokeKey 吊销虚拟密钥，立即生效
+func handleAdminRevokeKey(c *gin.Context) {
+	if !requireVirtualKeys(c) {
+		return
+	}
+	key, err := virtualKeys.Revoke(c.Param("id"))
+	if errors.Is(err, errVirtualKeyUnknown) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "key.revoke", key.ID, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"virtual_key": key.View()})
+}
+
+// handleAdminGetKey 返回单个虚拟密钥
+func handleAdminGetKey(c *gin.Context) {
+	if !requireVirtualKeys(c) {
+		return
+	}
+	key, ok := virtualKeys.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": errVirtualKeyUnknown.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"virtual_key": key.View()})
+}
+
+// handleAdminUpdateKey 修改虚拟密钥，请求中省略的字段保持不变；字段设为null表示清除
+// credentials整体替换而不是合并
+func handleAdminUpdateKey(c *gin.Context) {
+	if !requireVirtualKeys(c) {
+		return
+	}
+	var request map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	fields := map[string]func(key *VirtualKey) interface{}{
+		"name":        func(key *VirtualKey) interface{} { return &key.Name },
+		"owner":       func(key *VirtualKey) interface{} { return &key.Owner },
+		"team":        func(key *VirtualKey) interface{} { return &key.Team },
+		"credentials": func(key *VirtualKey) interface{} { key.Credentials = nil; return &key.Credentials },
+		"expires_at":  func(key *VirtualKey) interface{} { key.ExpiresAt = nil; return &key.ExpiresAt },
+		"rate_limit":  func(key *VirtualKey) interface{} { key.RateLimit = nil; return &key.RateLimit },
+		"budget":      func(key *VirtualKey) interface{} { key.Budget = nil; return &key.Budget },
+		"policy":      func(key *VirtualKey) interface{} { key.Policy = nil; return &key.Policy },
+	}
+	for name := range request {
+		if _, ok := fields[name]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Field %q cannot be updated", name)})
+			return
+		}
+	}
+
+	old, updated, err := virtualKeys.Update(c.Param("id"), func(key *VirtualKey) error {
+		for name, raw := range request {
+			if err := json.Unmarshal(raw, fields[name](key)); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errVirtualKeyUnknown) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "key.update", updated.ID, old.View(), updated.View())
+	c.JSON(http.StatusOK, gin.H{"virtual_key": updated.View()})
+}
+
+// usageView 管理接口返回的用量，附带虚拟密钥的名称和团队
+type usageView struct {
+	Subject string `json:"subject"` // key:虚拟密钥ID 或 team:团队名
+	Name    string `json:"name,omitempty"`
+	Team    string `json:"team,omitempty"`
+	UsageRecord
+}
+
+// handleAdminUsage 查询用量，可按key(虚拟密钥ID)或team过滤
+func handleAdminUsage(c *gin.Context) {
+	if spend == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Budgets are not enabled"})
+		return
+	}
+	prefix := ""
+	switch {
+	case c.Query("key") != "":
+		prefix = "key:" + c.Query("key")
+	case c.Query("team") != "":
+		prefix = "team:" + c.Query("team")
+	}
+
+	views := []usageView{}
+	for subject, record := range spend.Snapshot(prefix) {
+		// 前缀匹配只用于缩小范围，ID和团队名需要完全相同
+		if prefix != "" && subject != prefix {
+			continue
+		}
+		view := usageView{Subject: subject, UsageRecord: record}
+		if id := strings.TrimPrefix(subject, "key:"); id != subject && virtualKeys != nil {
+			if key, ok := virtualKeys.Get(id); ok {
+				view.Name, view.Team = key.Name, key.Team
+			}
+		} else {
+			view.Team = strings.TrimPrefix(subject, "team:")
+		}
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Subject < views[j].Subject })
+	c.JSON(http.StatusOK, gin.H{"usage": views})
+}
+
+// handleAdminAudit 返回最近的管理操作审计记录，默认100条
+func handleAdminAudit(c *gin.Context) {
+	limit := 100
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = parsed
+	}
+	entries, err := readAudit(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maskedSecret 管理接口中代替真实密钥显示的占位符；提交时原样带回表示保持原值
+const maskedSecret = "********"
+
+// adminMu 串行化管理接口对配置文件的读-改-写
+var adminMu sync.Mutex
+
+// routingConfig 管理接口可以修改的路由配置
+type routingConfig struct {
+	Upstreams       map[string]UpstreamConfig `json:"upstreams"`
+	ModelRules      []ModelRule               `json:"model_rules"`
+	ModelMappings   map[string]string         `json:"model_mappings"`
+	DefaultUpstream string                    `json:"default_upstream"`
+}
+
+// currentRoutingConfig 返回当前路由配置的副本
+func currentRoutingConfig() routingConfig {
+	routingMu.RLock()
+	defer routingMu.RUnlock()
+
+	config := routingConfig{
+		Upstreams:       make(map[string]UpstreamConfig),
+		ModelRules:      append([]ModelRule(nil), env.ModelRules...),
+		ModelMappings:   make(map[string]string),
+		DefaultUpstream: env.DefaultUpstream,
+	}
+	for name, upstream := range env.Upstreams {
+		config.Upstreams[name] = upstream
+	}
+	for keyword, model := range env.ModelMappings {
+		config.ModelMappings[keyword] = model
+	}
+	return config
+}
+
+// applyRoutingConfig 校验并应用新的路由配置
+// 先创建上游、编译规则，成功后原子地写回配置文件，最后替换运行中的配置；任何一步失败都不影响当前配置
+// 替换后关闭不再使用的旧上游的空闲连接
+func applyRoutingConfig(config routingConfig) error {
+	rules, err := compileModelRules(config.ModelRules, config.ModelMappings)
+	if err != nil {
+		return err
+	}
+
+	routingMu.RLock()
+	previous := upstreams
+	routingMu.RUnlock()
+	built, def, err := buildUpstreams(config.Upstreams, config.DefaultUpstream, previous)
+	if err != nil {
+		return err
+	}
+
+	if err := saveRoutingConfig(config); err != nil {
+		return fmt.Errorf("save config: %w", err)
+	}
+
+	routingMu.Lock()
+	env.Upstreams = config.Upstreams
+	env.ModelRules = config.ModelRules
+	env.ModelMappings = config.ModelMappings
+	env.DefaultUpstream = config.DefaultUpstream
+	upstreams = built
+	defaultUpstream = def
+	modelRules = rules
+	routingMu.Unlock()
+	closeReplacedUpstreams(previous, built)
+	return nil
+}
+
+// saveRoutingConfig 把路由配置写回配置文件，保留文件中的其他配置项
+func saveRoutingConfig(config routingConfig) error {
+	document := make(map[string]json.RawMessage)
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(configPath); err == nil {
+		mode = info.Mode().Perm()
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return fmt.Errorf("parse %s: %w", configPath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"upstreams":        config.Upstreams,
+		"model_rules":      config.ModelRules,
+		"model_mappings":   config.ModelMappings,
+		"default_upstream": config.DefaultUpstream,
+	}
+	for name, value := range fields {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		document[name] = raw
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(configPath, append(data, '\n'), mode)
+}
+
+// isSecretHeader 判断请求头是否可能包含凭据
+func isSecretHeader(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "auth") || strings.Contains(name, "key") || strings.Contains(name, "token")
+}
+
+// maskSecret 隐藏密钥；${ENV} 引用本身不含密钥，原样显示
+func maskSecret(value string) string {
+	if value == "" || (strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}")) {
+		return value
+	}
+	return maskedSecret
+}
+
+// maskUpstreamConfig 返回隐藏了密钥的上游配置
+func maskUpstreamConfig(config UpstreamConfig) UpstreamConfig {
+	config.APIKey = maskSecret(config.APIKey)
+	if config.Headers != nil {
+		headers := make(map[string]string, len(config.Headers))
+		for name, value := range config.Headers {
+			if isSecretHeader(name) {
+				value = maskSecret(value)
+			}
+			headers[name] = value
+		}
+		config.Headers = headers
+	}
+	if config.KeyPool != nil {
+		pool := *config.KeyPool
+		pool.Keys = make([]PoolKeyConfig, len(config.KeyPool.Keys))
+		for i, key := range config.KeyPool.Keys {
+			key.Key = maskSecret(key.Key)
+			pool.Keys[i] = key
+		}
+		config.KeyPool = &pool
+	}
+	return config
+}
+
+// unmaskUpstreamConfig 把提交的配置中的占位符还原为原配置中的密钥
+func unmaskUpstreamConfig(config, previous UpstreamConfig) UpstreamConfig {
+	if config.APIKey == maskedSecret {
+		config.APIKey = previous.APIKey
+	}
+	for name, value := range config.Headers {
+		if value == maskedSecret {
+			config.Headers[name] = previous.Headers[name]
+		}
+	}
+	if config.KeyPool != nil {
+		previousKeys := make(map[string]string)
+		if previous.KeyPool != nil {
+			for i, key := range previous.KeyPool.Keys {
+				name := key.Name
+				if name == "" {
+					name = fmt.Sprintf("key-%d", i+1)
+				}
+				previousKeys[name] = key.Key
+			}
+		}
+		for i, key := range config.KeyPool.Keys {
+			if key.Key != maskedSecret {
+				continue
+			}
+			name := key.Name
+			if name == "" {
+				name = fmt.Sprintf("key-%d", i+1)
+			}
+			config.KeyPool.Keys[i].Key = previousKeys[name]
+		}
+	}
+	return config
+}
+
+// upstreamView 管理接口返回的上游信息
+type upstreamView struct {
+	Name     string         `json:"name"`
+	Provider string         `json:"provider"`
+	Default  bool           `json:"default,omitempty"`
+	Editable bool           `json:"editable"` // 由upstreams配置定义，可以通过管理接口修改
+	Config   UpstreamConfig `json:"config"`
+}
+
+// handleAdminListUpstreams 列出所有上游，密钥被隐藏
+func handleAdminListUpstreams(c *gin.Context) {
+	routingMu.RLock()
+	views := make([]upstreamView, 0, len(upstreams))
+	for name, upstream := range upstreams {
+		config := upstream.Config
+		_, editable := env.Upstreams[name]
+		if editable {
+			// 显示配置文件中的原始值，保留 ${ENV} 引用
+			config = env.Upstreams[name]
+		}
+		views = append(views, upstreamView{
+			Name:     name,
+			Provider: upstream.Provider.Name(),
+			Default:  upstream == defaultUpstream,
+			Editable: editable,
+			Config:   maskUpstreamConfig(config),
+		})
+	}
+	routingMu.RUnlock()
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	c.JSON(http.StatusOK, gin.H{"upstreams": views})
+}
+
+// handleAdminPutUpstream 创建或替换上游，立即生效
+func handleAdminPutUpstream(c *gin.Context) {
+	name := c.Param("name")
+	if name == passthroughUpstreamName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The passthrough upstream is configured by the passthrough section"})
+		return
+	}
+	var upstreamConfig UpstreamConfig
+	if err := c.ShouldBindJSON(&upstreamConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	adminMu.Lock()
+	defer adminMu.Unlock()
+
+	config := currentRoutingConfig()
+	previous, existed := config.Upstreams[name]
+	upstreamConfig = unmaskUpstreamConfig(upstreamConfig, previous)
+	config.Upstreams[name] = upstreamConfig
+	if err := applyRoutingConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var before interface{}
+	if existed {
+		before = maskUpstreamConfig(previous)
+	}
+	recordAudit(c, "upstream.put", name, before, maskUpstreamConfig(upstreamConfig))
+	c.JSON(http.StatusOK, gin.H{"name": name, "config": maskUpstreamConfig(upstreamConfig)})
+}
+
+// handleAdminDeleteUpstream 删除上游，默认上游不能删除
+func handleAdminDeleteUpstream(c *gin.Context) {
+	name := c.Param("name")
+
+	adminMu.Lock()
+	defer adminMu.Unlock()
+
+	config := currentRoutingConfig()
+	previous, ok := config.Upstreams[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("upstream %q is not defined in upstreams", name)})
+		return
+	}
+	delete(config.Upstreams, name)
+	if err := applyRoutingConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, "upstream.delete", name, maskUpstreamConfig(previous), nil)
+	c.JSON(http.StatusOK, gin.H{"deleted": name})
+}
+
+// handleAdminGetModelRules 返回模型映射规则和默认上游
+func handleAdminGetModelRules(c *gin.Context) {
+	config := currentRoutingConfig()
+	c.JSON(http.StatusOK, gin.H{
+		"model_rules":      config.ModelRules,
+		"model_mappings":   config.ModelMappings,
+		"default_upstream": config.DefaultUpstream,
+	})
+}
+
+// handleAdminPutModelRules 修改模型映射规则，请求中省略的字段保持不变
+func handleAdminPutModelRules(c *gin.Context) {
+	var request struct {
+		ModelRules      *[]ModelRule       `json:"model_rules"`
+		ModelMappings   *map[string]string `json:"model_mappings"`
+		DefaultUpstream *string            `json:"default_upstream"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	adminMu.Lock()
+	defer adminMu.Unlock()
+
+	config := currentRoutingConfig()
+	before := gin.H{
+		"model_rules":      config.ModelRules,
+		"model_mappings":   config.ModelMappings,
+		"default_upstream": config.DefaultUpstream,
+	}
+	if request.ModelRules != nil {
+		config.ModelRules = *request.ModelRules
+	}
+	if request.ModelMappings != nil {
+		config.ModelMappings = *request.ModelMappings
+	}
+	if request.DefaultUpstream != nil {
+		config.DefaultUpstream = *request.DefaultUpstream
+	}
+	if err := applyRoutingConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	after := gin.H{
+		"model_rules":      config.ModelRules,
+		"model_mappings":   config.ModelMappings,
+		"default_upstream": config.DefaultUpstream,
+	}
+	recordAudit(c, "model_rules.update", "", before, after)
+	c.JSON(http.StatusOK, after)
+}
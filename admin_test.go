@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useTestAdminEnv 替换管理接口相关的全局配置，测试结束后恢复
+func useTestAdminEnv(t *testing.T, token string, allowLocalhost bool) {
+	t.Helper()
+	previous := env
+	env.AdminToken = token
+	env.AdminTokens = nil
+	env.AdminAllowLocalhost = allowLocalhost
+	env.AdminAuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Cleanup(func() { env = previous })
+}
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name           string
+		token          string
+		allowLocalhost bool
+		remoteAddr     string
+		header         string
+		want           int
+	}{
+		{"no token disables admin API", "", false, "127.0.0.1:1234", "", http.StatusForbidden},
+		{"no token and remote client", "", false, "203.0.113.7:1234", "", http.StatusForbidden},
+		{"localhost opt-in", "", true, "127.0.0.1:1234", "", http.StatusOK},
+		{"localhost opt-in over IPv6", "", true, "[::1]:1234", "", http.StatusOK},
+		{"localhost opt-in rejects remote client", "", true, "203.0.113.7:1234", "", http.StatusForbidden},
+		{"valid bearer token", "secret", false, "203.0.113.7:1234", "Bearer secret", http.StatusOK},
+		{"invalid token", "secret", false, "203.0.113.7:1234", "Bearer wrong", http.StatusUnauthorized},
+		{"token required even from localhost", "secret", true, "127.0.0.1:1234", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestAdminEnv(t, tt.token, tt.allowLocalhost)
+			r := gin.New()
+			r.GET("/admin/ping", requireAdmin, func(c *gin.Context) { c.Status(http.StatusOK) })
+			req := httptest.NewRequest(http.MethodGet, "/admin/ping", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDebugRouteRequiresAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"anonymous", "", http.StatusUnauthorized},
+		{"admin token", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestAdminEnv(t, "secret", false)
+			useTestUpstreams(t, map[string]Provider{"up": &fakeProvider{}}, "up")
+			r := gin.New()
+			registerAdminRoutes(r)
+			req := httptest.NewRequest(http.MethodGet, "/debug/route?model=claude", nil)
+			req.RemoteAddr = "203.0.113.7:1234"
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleAdminUpdateKey(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC()
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		check  func(t *testing.T, key *VirtualKey)
+	}{
+		{
+			name:   "omitted fields are kept",
+			body:   `{"name": "renamed"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, key *VirtualKey) {
+				if key.Name != "renamed" || key.Team != "research" || key.RateLimit == nil || key.ExpiresAt == nil || key.Policy == nil {
+					t.Fatalf("key = %+v, want only name changed", key)
+				}
+			},
+		},
+		{
+			name:   "null clears optional fields",
+			body:   `{"rate_limit": null, "expires_at": null, "policy": null, "team": null}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, key *VirtualKey) {
+				if key.RateLimit != nil || key.ExpiresAt != nil || key.Policy != nil {
+					t.Fatalf("key = %+v, want rate_limit, expires_at and policy cleared", key)
+				}
+				if key.Team != "research" || key.Name != "ci" {
+					t.Fatalf("key = %+v, want null to leave string fields unchanged", key)
+				}
+			},
+		},
+		{
+			name:   "replacing a nested object drops its old fields",
+			body:   `{"rate_limit": {"concurrent_streams": 2}}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, key *VirtualKey) {
+				if key.RateLimit == nil || *key.RateLimit != (RateLimitConfig{ConcurrentStreams: 2}) {
+					t.Fatalf("rate_limit = %+v, want replaced", key.RateLimit)
+				}
+			},
+		},
+		{"unknown field", `{"hash": "x"}`, http.StatusBadRequest, nil},
+		{"unknown credential upstream", `{"credentials": {"missing": "sk"}}`, http.StatusBadRequest, nil},
+		{"empty name", `{"name": ""}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestAdminEnv(t, "secret", false)
+			store, err := loadVirtualKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			previous := virtualKeys
+			virtualKeys = store
+			t.Cleanup(func() { virtualKeys = previous })
+			key, _, err := store.Create(VirtualKey{
+				Name:      "ci",
+				Team:      "research",
+				ExpiresAt: &expires,
+				RateLimit: &RateLimitConfig{RequestsPerMinute: 10},
+				Policy:    &KeyPolicy{Models: []string{"*haiku*"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := gin.New()
+			registerAdminRoutes(r)
+			req := httptest.NewRequest(http.MethodPatch, "/admin/keys/"+key.ID, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			updated, _ := store.Get(key.ID)
+			if tt.check != nil {
+				tt.check(t, updated)
+				// 修改已写回存储文件
+				reloaded, err := loadVirtualKeyStore(store.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				saved, _ := reloaded.Get(key.ID)
+				savedJSON, _ := json.Marshal(saved.View())
+				updatedJSON, _ := json.Marshal(updated.View())
+				if string(savedJSON) != string(updatedJSON) {
+					t.Fatalf("saved key = %s, want %s", savedJSON, updatedJSON)
+				}
+			} else if updated != key {
+				t.Fatal("rejected update modified the key")
+			}
+		})
+	}
+}
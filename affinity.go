@@ -0,0 +1,166 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// CacheAffinityConfig 提示词缓存亲和配置
+type CacheAffinityConfig struct {
+	Enabled    bool `json:"enabled"`
+	TTLSeconds int  `json:"ttl_seconds,omitempty"` // 会话多久没有请求后忘记绑定，默认3600
+	MaxEntries int  `json:"max_entries,omitempty"` // 最多保存的会话数，默认10000
+}
+
+// affinityEntry 会话绑定的上游、模型和密钥
+type affinityEntry struct {
+	affinityKey string
+	upstream    string
+	model       string
+	key         string
+	lastUsed    time.Time
+}
+
+// affinityStore 会话亲和表，按最近使用顺序排列，最久未使用的在链表末尾
+type affinityStore struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+var cacheAffinity = newAffinityStore()
+
+// newAffinityStore 创建空的会话亲和表
+func newAffinityStore() *affinityStore {
+	return &affinityStore{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// conversationAffinityKey 计算会话亲和键：客户端标识、请求模型、system提示词和第一条用户消息的哈希
+// 同一会话的后续轮次只在末尾追加消息，因此得到相同的键；不同客户端发送相同的开场消息不会共享绑定
+func conversationAffinityKey(request MessageCreateParamsBase, clientID string) string {
+	if !env.CacheAffinity.Enabled {
+		return ""
+	}
+	var firstUser string
+	for _, message := range request.Messages {
+		if message.Role == "user" {
+			firstUser = contentText(message.Content)
+			break
+		}
+	}
+	if firstUser == "" {
+		return ""
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(clientID))
+	hash.Write([]byte{0})
+	hash.Write([]byte(request.Model))
+	hash.Write([]byte{0})
+	hash.Write([]byte(systemPromptText(request.System)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(firstUser))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ttl 返回绑定的有效期
+func (s *affinityStore) ttl() time.Duration {
+	if env.CacheAffinity.TTLSeconds > 0 {
+		return time.Duration(env.CacheAffinity.TTLSeconds) * time.Second
+	}
+	return time.Hour
+}
+
+// Apply 将路由中与会话绑定一致的目标提到最前面，并指定优先使用的密钥
+// 绑定的目标不在当前路由中(如规则已修改)时保持原路由
+func (s *affinityStore) Apply(route Route, affinityKey string) Route {
+	if affinityKey == "" {
+		return route
+	}
+
+	s.mu.Lock()
+	var entry affinityEntry
+	element, ok := s.entries[affinityKey]
+	if ok {
+		entry = *element.Value.(*affinityEntry)
+		if time.Since(entry.lastUsed) > s.ttl() {
+			s.remove(element)
+			ok = false
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		return route
+	}
+
+	targets := route.Targets()
+	for i, target := range targets {
+		if target.Upstream.Name != entry.upstream || target.Model != entry.model {
+			continue
+		}
+		target.PreferredKey = entry.key
+		var fallbacks []RouteTarget
+		fallbacks = append(fallbacks, targets[:i]...)
+		fallbacks = append(fallbacks, targets[i+1:]...)
+		route.Upstream = target.Upstream
+		route.Model = target.Model
+		route.PreferredKey = target.PreferredKey
+		route.Fallbacks = fallbacks
+		return route
+	}
+	return route
+}
+
+// Remember 记录会话实际使用的上游、模型和密钥
+func (s *affinityStore) Remember(affinityKey string, result *upstreamResult) {
+	if affinityKey == "" || result.Target.Upstream == nil {
+		return
+	}
+	var key string
+	if len(result.Attempts) > 0 {
+		key = result.Attempts[len(result.Attempts)-1].Key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry := &affinityEntry{
+		affinityKey: affinityKey,
+		upstream:    result.Target.Upstream.Name,
+		model:       result.Target.Model,
+		key:         key,
+		lastUsed:    now,
+	}
+	if element, ok := s.entries[affinityKey]; ok {
+		element.Value = entry
+		s.order.MoveToFront(element)
+	} else {
+		s.entries[affinityKey] = s.order.PushFront(entry)
+	}
+	s.prune(now)
+}
+
+// prune 从最久未使用的一端移除过期的绑定以及超过上限的绑定，调用方需持有锁
+func (s *affinityStore) prune(now time.Time) {
+	maxEntries := env.CacheAffinity.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	ttl := s.ttl()
+	for oldest := s.order.Back(); oldest != nil; oldest = s.order.Back() {
+		if len(s.entries) <= maxEntries && now.Sub(oldest.Value.(*affinityEntry).lastUsed) <= ttl {
+			return
+		}
+		s.remove(oldest)
+	}
+}
+
+// remove 删除一个绑定，调用方需持有锁
+func (s *affinityStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*affinityEntry).affinityKey)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// useTestCacheAffinity 替换缓存亲和配置，测试结束后恢复
+func useTestCacheAffinity(t *testing.T, config CacheAffinityConfig) {
+	t.Helper()
+	previous := env.CacheAffinity
+	env.CacheAffinity = config
+	t.Cleanup(func() { env.CacheAffinity = previous })
+}
+
+func TestConversationAffinityKey(t *testing.T) {
+	useTestCacheAffinity(t, CacheAffinityConfig{Enabled: true})
+	first := parseAnthropicRequest(t, `{"model": "m", "system": "sys", "messages": [{"role": "user", "content": "hello"}]}`)
+	base := conversationAffinityKey(first, "vk_a")
+
+	tests := []struct {
+		name     string
+		request  string
+		clientID string
+		wantSame bool
+	}{
+		{"later turn of the same conversation", `{"model": "m", "system": "sys", "messages": [
+			{"role": "user", "content": "hello"}, {"role": "assistant", "content": "hi"}, {"role": "user", "content": "more"}]}`, "vk_a", true},
+		{"other client with the same opening", `{"model": "m", "system": "sys", "messages": [{"role": "user", "content": "hello"}]}`, "vk_b", false},
+		{"different system prompt", `{"model": "m", "system": "other", "messages": [{"role": "user", "content": "hello"}]}`, "vk_a", false},
+		{"different model", `{"model": "m2", "system": "sys", "messages": [{"role": "user", "content": "hello"}]}`, "vk_a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := conversationAffinityKey(parseAnthropicRequest(t, tt.request), tt.clientID)
+			if (key == base) != tt.wantSame {
+				t.Fatalf("same key = %v, want %v", key == base, tt.wantSame)
+			}
+		})
+	}
+
+	if key := conversationAffinityKey(parseAnthropicRequest(t, `{"model": "m", "messages": []}`), "vk_a"); key != "" {
+		t.Fatalf("key without user message = %q, want empty", key)
+	}
+	env.CacheAffinity.Enabled = false
+	if key := conversationAffinityKey(first, "vk_a"); key != "" {
+		t.Fatalf("key while disabled = %q, want empty", key)
+	}
+}
+
+func TestAffinityStoreApply(t *testing.T) {
+	useTestCacheAffinity(t, CacheAffinityConfig{Enabled: true, TTLSeconds: 60})
+	a, b := &Upstream{Name: "a"}, &Upstream{Name: "b"}
+	route := Route{Upstream: a, Model: "m", Fallbacks: []RouteTarget{{Upstream: b, Model: "m"}}}
+	remembered := &upstreamResult{Target: RouteTarget{Upstream: b, Model: "m"}, Attempts: []UpstreamAttempt{{Key: "key-2"}}}
+
+	tests := []struct {
+		name      string
+		remember  *upstreamResult
+		age       time.Duration
+		wantFirst string
+		wantKey   string
+	}{
+		{"no binding", nil, 0, "a", ""},
+		{"bound target moves first with its key", remembered, 0, "b", "key-2"},
+		{"expired binding is ignored", remembered, 2 * time.Minute, "a", ""},
+		{"bound target no longer routed", &upstreamResult{Target: RouteTarget{Upstream: &Upstream{Name: "gone"}, Model: "m"}}, 0, "a", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newAffinityStore()
+			if tt.remember != nil {
+				store.Remember("conv", tt.remember)
+				store.entries["conv"].Value.(*affinityEntry).lastUsed = time.Now().Add(-tt.age)
+			}
+			got := store.Apply(route, "conv")
+			if got.Upstream.Name != tt.wantFirst || got.PreferredKey != tt.wantKey || len(got.Targets()) != 2 {
+				t.Fatalf("route = %s key %q with %d targets, want %s key %q", got.Upstream.Name, got.PreferredKey, len(got.Targets()), tt.wantFirst, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestAffinityStoreEviction(t *testing.T) {
+	useTestCacheAffinity(t, CacheAffinityConfig{Enabled: true, TTLSeconds: 60, MaxEntries: 3})
+	result := &upstreamResult{Target: RouteTarget{Upstream: &Upstream{Name: "a"}, Model: "m"}}
+	tests := []struct {
+		name    string
+		setup   func(store *affinityStore)
+		want    []string
+		missing []string
+	}{
+		{
+			name: "least recently used is evicted",
+			setup: func(store *affinityStore) {
+				for i := 0; i < 4; i++ {
+					store.Remember(fmt.Sprint(i), result)
+				}
+			},
+			want:    []string{"1", "2", "3"},
+			missing: []string{"0"},
+		},
+		{
+			name: "remembering again refreshes the binding",
+			setup: func(store *affinityStore) {
+				store.Remember("0", result)
+				store.Remember("1", result)
+				store.Remember("2", result)
+				store.Remember("0", result)
+				store.Remember("3", result)
+			},
+			want:    []string{"0", "2", "3"},
+			missing: []string{"1"},
+		},
+		{
+			name: "expired bindings are dropped",
+			setup: func(store *affinityStore) {
+				store.Remember("old", result)
+				store.entries["old"].Value.(*affinityEntry).lastUsed = time.Now().Add(-time.Hour)
+				store.Remember("new", result)
+			},
+			want:    []string{"new"},
+			missing: []string{"old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newAffinityStore()
+			tt.setup(store)
+			for _, key := range tt.want {
+				if _, ok := store.entries[key]; !ok {
+					t.Fatalf("binding %q missing", key)
+				}
+			}
+			for _, key := range tt.missing {
+				if _, ok := store.entries[key]; ok {
+					t.Fatalf("binding %q should have been evicted", key)
+				}
+			}
+			if len(store.entries) != len(tt.want) || store.order.Len() != len(tt.want) {
+				t.Fatalf("%d entries, %d in order, want %d", len(store.entries), store.order.Len(), len(tt.want))
+			}
+		})
+	}
+}
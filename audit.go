@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditEntry 管理操作审计记录
+type AuditEntry struct {
+	Time       time.Time   `json:"time"`
+	Actor      string      `json:"actor"` // 管理令牌名称，未配置令牌时为localhost
+	RemoteAddr string      `json:"remote_addr"`
+	Action     string      `json:"action"` // 如 key.create、upstream.update、model_rules.update
+	Target     string      `json:"target,omitempty"`
+	Before     interface{} `json:"before,omitempty"`
+	After      interface{} `json:"after,omitempty"`
+}
+
+// auditMu 串行化审计日志的追加写入
+var auditMu sync.Mutex
+
+// auditLogPath 返回审计日志文件路径
+func auditLogPath() string {
+	if env.AdminAuditLog != "" {
+		return env.AdminAuditLog
+	}
+	return "admin_audit.jsonl"
+}
+
+// recordAudit 追加一条审计记录，每行一个JSON对象
+// 审计记录写入失败不回滚已经生效的修改，只记录错误
+func recordAudit(c *gin.Context, action, target string, before, after interface{}) {
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		Actor:      c.GetString(adminActorKey),
+		RemoteAddr: c.ClientIP(),
+		Action:     action,
+		Target:     target,
+		Before:     before,
+		After:      after,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode audit entry: %v", err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	file, err := os.OpenFile(auditLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to open audit log: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// readAudit 读取最近的limit条审计记录，按时间倒序返回
+func readAudit(limit int) ([]AuditEntry, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	entries := []AuditEntry{}
+	file, err := os.Open(auditLogPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen 上游熔断器处于打开状态
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// 熔断器状态
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// CircuitBreakerConfig 熔断器配置，按上游和模型分别统计
+type CircuitBreakerConfig struct {
+	WindowSeconds    int     `json:"window_seconds,omitempty"`     // 统计窗口，默认60秒
+	MinRequests      int     `json:"min_requests,omitempty"`       // 窗口内请求数达到该值才判断，默认10
+	ErrorRate        float64 `json:"error_rate,omitempty"`         // 失败率阈值，默认0.5
+	SlowCallMs       int     `json:"slow_call_ms,omitempty"`       // 首个响应头超过该时间视为慢请求，0表示不统计
+	SlowCallRate     float64 `json:"slow_call_rate,omitempty"`     // 慢请求比例阈值，默认0.5
+	OpenSeconds      int     `json:"open_seconds,omitempty"`       // 打开后多久进入半开状态，默认30秒
+	HalfOpenRequests int     `json:"half_open_requests,omitempty"` // 半开状态放行的探测请求数，全部成功后关闭，默认1
+}
+
+// breakerOutcome 一次请求的结果
+type breakerOutcome struct {
+	at     time.Time
+	failed bool
+	slow   bool
+}
+
+// circuitBreaker 单个上游模型的熔断器
+type circuitBreaker struct {
+	mu       sync.Mutex
+	config   CircuitBreakerConfig
+	state    string
+	outcomes []breakerOutcome
+	openedAt time.Time
+	opens    int
+
+	halfOpenInFlight  int
+	halfOpenSuccesses int
+}
+
+// BreakerStatus 熔断器状态快照
+type BreakerStatus struct {
+	Upstream  string  `json:"upstream"`
+	Model     string  `json:"model"`
+	State     string  `json:"state"`
+	Requests  int     `json:"requests"`
+	Failures  int     `json:"failures"`
+	SlowCalls int     `json:"slow_calls"`
+	ErrorRate float64 `json:"error_rate"`
+	OpenedAt  string  `json:"opened_at,omitempty"`
+	Opens     int     `json:"opens"`
+}
+
+// newCircuitBreaker 创建熔断器并补全默认配置
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	if config.WindowSeconds <= 0 {
+		config.WindowSeconds = 60
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = 10
+	}
+	if config.ErrorRate <= 0 {
+		config.ErrorRate = 0.5
+	}
+	if config.SlowCallRate <= 0 {
+		config.SlowCallRate = 0.5
+	}
+	if config.OpenSeconds <= 0 {
+		config.OpenSeconds = 30
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	return &circuitBreaker{config: config, state: breakerClosed}
+}
+
+// Allow 判断是否放行请求；打开状态超时后进入半开状态并放行有限的探测请求
+// 放行的请求必须调用Record或Release
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < time.Duration(b.config.OpenSeconds)*time.Second {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.halfOpenInFlight = 0
+		b.halfOpenSuccesses = 0
+		fallthrough
+	case breakerHalfOpen:
+		if b.halfOpenInFlight+b.halfOpenSuccesses >= b.config.HalfOpenRequests {
+			return false
+		}
+		b.halfOpenInFlight++
+	}
+	return true
+}
+
+// Record 记录请求结果并更新状态
+func (b *circuitBreaker) Record(failed bool, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	slow := b.config.SlowCallMs > 0 && latency > time.Duration(b.config.SlowCallMs)*time.Millisecond
+
+	if b.state == breakerHalfOpen {
+		if b.halfOpenInFlight > 0 {
+			b.halfOpenInFlight--
+		}
+		if failed || slow {
+			b.trip(now)
+			return
+		}
+		b.halfOpenSuccesses++
+		if b.halfOpenSuccesses >= b.config.HalfOpenRequests {
+			b.state = breakerClosed
+			b.outcomes = nil
+		}
+		return
+	}
+	if b.state == breakerOpen {
+		return
+	}
+
+	b.outcomes = append(b.outcomes, breakerOutcome{at: now, failed: failed, slow: slow})
+	b.prune(now)
+
+	total, failures, slowCalls := b.counts()
+	if total < b.config.MinRequests {
+		return
+	}
+	if float64(failures)/float64(total) >= b.config.ErrorRate ||
+		(b.config.SlowCallMs > 0 && float64(slowCalls)/float64(total) >= b.config.SlowCallRate) {
+		b.trip(now)
+	}
+}
+
+// Release 放弃已放行但没有结果的请求(如客户端断开)，释放半开状态的探测名额
+func (b *circuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen && b.halfOpenInFlight > 0 {
+		b.halfOpenInFlight--
+	}
+}
+
+// trip 打开熔断器
+func (b *circuitBreaker) trip(now time.Time) {
+	b.state = breakerOpen
+	b.openedAt = now
+	b.opens++
+	b.outcomes = nil
+	b.halfOpenInFlight = 0
+	b.halfOpenSuccesses = 0
+}
+
+// prune 移除统计窗口之外的结果
+func (b *circuitBreaker) prune(now time.Time) {
+	cutoff := now.Add(-time.Duration(b.config.WindowSeconds) * time.Second)
+	i := 0
+	for i < len(b.outcomes) && b.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	b.outcomes = b.outcomes[i:]
+}
+
+// counts 统计窗口内的请求数、失败数和慢请求数
+func (b *circuitBreaker) counts() (total, failures, slowCalls int) {
+	for _, outcome := range b.outcomes {
+		total++
+		if outcome.failed {
+			failures++
+		}
+		if outcome.slow {
+			slowCalls++
+		}
+	}
+	return total, failures, slowCalls
+}
+
+// Status 返回状态快照
+func (b *circuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(time.Now())
+	total, failures, slowCalls := b.counts()
+	status := BreakerStatus{
+		State:     b.state,
+		Requests:  total,
+		Failures:  failures,
+		SlowCalls: slowCalls,
+		Opens:     b.opens,
+	}
+	if b.state == breakerOpen && time.Since(b.openedAt) >= time.Duration(b.config.OpenSeconds)*time.Second {
+		status.State = breakerHalfOpen
+	}
+	if total > 0 {
+		status.ErrorRate = float64(failures) / float64(total)
+	}
+	if !b.openedAt.IsZero() {
+		status.OpenedAt = b.openedAt.Format(time.RFC3339)
+	}
+	return status
+}
+
+// Reset 手动关闭熔断器
+func (b *circuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.outcomes = nil
+	b.halfOpenInFlight = 0
+	b.halfOpenSuccesses = 0
+}
+
+// breakerFailure 判断上游结果是否计为熔断器失败：连接错误、408和5xx
+func breakerFailure(statusCode int, err error) bool {
+	if err != nil {
+		return true
+	}
+	return statusCode == 408 || statusCode >= 500
+}
+
+// breakerFor 返回上游指定模型的熔断器，未配置熔断时返回nil
+func (u *Upstream) breakerFor(model string) *circuitBreaker {
+	if u.Config.CircuitBreaker == nil {
+		return nil
+	}
+	u.breakersMu.Lock()
+	defer u.breakersMu.Unlock()
+
+	if u.breakers == nil {
+		u.breakers = make(map[string]*circuitBreaker)
+	}
+	breaker, ok := u.breakers[model]
+	if !ok {
+		breaker = newCircuitBreaker(*u.Config.CircuitBreaker)
+		u.breakers[model] = breaker
+	}
+	return breaker
+}
+
+// breakerStatuses 返回所有上游的熔断器状态
+func breakerStatuses() []BreakerStatus {
+	var statuses []BreakerStatus
+	routingMu.RLock()
+	defer routingMu.RUnlock()
+	for name, upstream := range upstreams {
+		upstream.breakersMu.Lock()
+		for model, breaker := range upstream.breakers {
+			status := breaker.Status()
+			status.Upstream = name
+			status.Model = model
+			statuses = append(statuses, status)
+		}
+		upstream.breakersMu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Upstream != statuses[j].Upstream {
+			return statuses[i].Upstream < statuses[j].Upstream
+		}
+		return statuses[i].Model < statuses[j].Model
+	})
+	return statuses
+}
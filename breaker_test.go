@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// breakerStep 对熔断器的一步操作：allow调用Allow并检查结果，record记录结果，elapse让打开状态超时
+type breakerStep struct {
+	op        string
+	failed    bool
+	latency   time.Duration
+	wantAllow bool
+	wantState string
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	config := CircuitBreakerConfig{MinRequests: 4, ErrorRate: 0.5, OpenSeconds: 30, HalfOpenRequests: 2, SlowCallMs: 100, SlowCallRate: 0.75}
+	fail := breakerStep{op: "record", failed: true}
+	ok := breakerStep{op: "record"}
+	slow := breakerStep{op: "record", latency: time.Second}
+	allow := func(want bool) breakerStep { return breakerStep{op: "allow", wantAllow: want} }
+	state := func(s breakerStep, want string) breakerStep { s.wantState = want; return s }
+	elapse := breakerStep{op: "elapse"}
+
+	tests := []struct {
+		name  string
+		steps []breakerStep
+	}{
+		{"below min requests stays closed", []breakerStep{
+			fail, fail, state(fail, breakerClosed), allow(true),
+		}},
+		{"error rate reached opens", []breakerStep{
+			ok, ok, fail, state(fail, breakerOpen), allow(false),
+		}},
+		{"error rate below threshold stays closed", []breakerStep{
+			ok, ok, ok, state(fail, breakerClosed),
+		}},
+		{"slow calls open", []breakerStep{
+			slow, slow, slow, state(ok, breakerOpen),
+		}},
+		{"half open limits probes", []breakerStep{
+			ok, ok, fail, fail, elapse,
+			allow(true), allow(true), state(allow(false), breakerHalfOpen),
+		}},
+		{"half open closes after all probes succeed", []breakerStep{
+			ok, ok, fail, fail, elapse,
+			allow(true), allow(true), state(ok, breakerHalfOpen), state(ok, breakerClosed), allow(true),
+		}},
+		{"half open failure reopens", []breakerStep{
+			ok, ok, fail, fail, elapse,
+			allow(true), state(fail, breakerOpen), allow(false),
+		}},
+		{"half open slow probe reopens", []breakerStep{
+			ok, ok, fail, fail, elapse,
+			allow(true), state(slow, breakerOpen),
+		}},
+		{"released probe frees its slot", []breakerStep{
+			ok, ok, fail, fail, elapse,
+			allow(true), allow(true), allow(false), {op: "release"}, allow(true),
+		}},
+		{"reset closes", []breakerStep{
+			ok, ok, fail, fail, state(breakerStep{op: "reset"}, breakerClosed), allow(true),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(config)
+			for i, step := range tt.steps {
+				switch step.op {
+				case "allow":
+					if got := b.Allow(); got != step.wantAllow {
+						t.Fatalf("step %d: Allow = %v, want %v", i, got, step.wantAllow)
+					}
+				case "record":
+					b.Record(step.failed, step.latency)
+				case "release":
+					b.Release()
+				case "reset":
+					b.Reset()
+				case "elapse":
+					b.mu.Lock()
+					b.openedAt = b.openedAt.Add(-time.Duration(b.config.OpenSeconds) * time.Second)
+					b.mu.Unlock()
+				}
+				if step.wantState != "" {
+					b.mu.Lock()
+					got := b.state
+					b.mu.Unlock()
+					if got != step.wantState {
+						t.Fatalf("step %d: state = %s, want %s", i, got, step.wantState)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerStatus(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreakerConfig{MinRequests: 2, OpenSeconds: 30})
+	b.Record(false, 0)
+	if status := b.Status(); status.State != breakerClosed || status.Requests != 1 || status.Failures != 0 {
+		t.Fatalf("status = %+v", status)
+	}
+	b.Record(true, 0)
+	if status := b.Status(); status.State != breakerOpen || status.Opens != 1 || status.OpenedAt == "" {
+		t.Fatalf("status = %+v", status)
+	}
+	b.mu.Lock()
+	b.openedAt = b.openedAt.Add(-time.Minute)
+	b.mu.Unlock()
+	// 超时后即使还没有请求触发状态切换，也显示为半开
+	if status := b.Status(); status.State != breakerHalfOpen {
+		t.Fatalf("state = %s, want %s", status.State, breakerHalfOpen)
+	}
+}
+
+func TestBreakerFailure(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		err        error
+		want       bool
+	}{
+		{200, nil, false},
+		{400, nil, false},
+		{429, nil, false},
+		{408, nil, true},
+		{500, nil, true},
+		{503, nil, true},
+		{0, errors.New("connection refused"), true},
+	}
+	for _, tt := range tests {
+		if got := breakerFailure(tt.statusCode, tt.err); got != tt.want {
+			t.Errorf("breakerFailure(%d, %v) = %v, want %v", tt.statusCode, tt.err, got, tt.want)
+		}
+	}
+}
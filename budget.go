@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BudgetSettings 用量统计与预算配置
+type BudgetSettings struct {
+	Enabled   bool                    `json:"enabled"`
+	Store     string                  `json:"store,omitempty"`      // 用量存储文件，默认usage.json
+	WarnRatio float64                 `json:"warn_ratio,omitempty"` // 用量达到预算的该比例时在响应头中警告，默认0.8
+	Prices    map[string]ModelPrice   `json:"prices,omitempty"`     // 键为 "上游名:模型名" 或上游模型名
+	PerKey    *BudgetConfig           `json:"per_key,omitempty"`    // 没有单独配置预算的虚拟密钥使用
+	Teams     map[string]BudgetConfig `json:"teams,omitempty"`
+	// DefaultPrice 没有在prices中配置价格的模型使用；未配置时这些模型只统计token，不计入花费
+	DefaultPrice *ModelPrice `json:"default_price,omitempty"`
+}
+
+// ModelPrice 模型价格，美元/百万token
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+	// CacheWrite、CacheRead 提示词缓存写入和读取的价格，0表示按Input的1.25倍和0.1倍计算
+	CacheWrite float64 `json:"cache_write,omitempty"`
+	CacheRead  float64 `json:"cache_read,omitempty"`
+}
+
+// cost 按用量计算花费
+func (p ModelPrice) cost(usage *AnthropicUsage) float64 {
+	cacheWrite, cacheRead := p.CacheWrite, p.CacheRead
+	if cacheWrite == 0 {
+		cacheWrite = p.Input * 1.25
+	}
+	if cacheRead == 0 {
+		cacheRead = p.Input * 0.1
+	}
+	return (float64(usage.InputTokens)*p.Input +
+		float64(usage.OutputTokens)*p.Output +
+		float64(usage.CacheCreationInputTokens)*cacheWrite +
+		float64(usage.CacheReadInputTokens)*cacheRead) / 1e6
+}
+
+// BudgetConfig 预算，金额为美元，0表示不限制；日和月按UTC计算
+type BudgetConfig struct {
+	DailyUSD   float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD float64 `json:"monthly_usd,omitempty"`
+	TotalUSD   float64 `json:"total_usd,omitempty"`
+	// Action 预算用完后的处理：reject(默认)拒绝请求，downgrade改用DowngradeModel
+	Action         string `json:"action,omitempty"`
+	DowngradeModel string `json:"downgrade_model,omitempty"` // 按模型映射规则解析的模型名
+}
+
+// UsageCounter 一个周期内的用量
+type UsageCounter struct {
+	Period                   string  `json:"period,omitempty"` // 日为 2006-01-02，月为 2006-01，累计为空
+	Requests                 int64   `json:"requests"`
+	InputTokens              int64   `json:"input_tokens"`
+	OutputTokens             int64   `json:"output_tokens"`
+	CacheCreationInputTokens int64   `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64   `json:"cache_read_input_tokens"`
+	CostUSD                  float64 `json:"cost_usd"`
+}
+
+// UsageRecord 一个虚拟密钥或团队的用量
+type UsageRecord struct {
+	Daily   UsageCounter `json:"daily"`
+	Monthly UsageCounter `json:"monthly"`
+	Total   UsageCounter `json:"total"`
+}
+
+// usageStore 持久化的用量统计，键为 "key:虚拟密钥ID" 或 "team:团队名"
+type usageStore struct {
+	mu       sync.Mutex
+	path     string
+	records  map[string]*UsageRecord
+	dirty    bool
+	unpriced map[string]bool // 已经警告过没有价格的模型
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var spend *usageStore
+
+// budgetFlushInterval 用量写回存储文件的间隔
+const budgetFlushInterval = 5 * time.Second
+
+// initBudgets 加载用量存储并启动定期写回
+func initBudgets() error {
+	if !env.Budgets.Enabled {
+		return nil
+	}
+	for team, budget := range env.Budgets.Teams {
+		if err := budget.validate(); err != nil {
+			return fmt.Errorf("team %q: %w", team, err)
+		}
+	}
+	if env.Budgets.PerKey != nil {
+		if err := env.Budgets.PerKey.validate(); err != nil {
+			return fmt.Errorf("per_key: %w", err)
+		}
+	}
+
+	path := env.Budgets.Store
+	if path == "" {
+		path = "usage.json"
+	}
+	store := newUsageStore(path)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read usage store: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &store.records); err != nil {
+			return fmt.Errorf("parse usage store %s: %w", path, err)
+		}
+	}
+	spend = store
+	go store.flushLoop()
+	log.Printf("Loaded usage for %d keys and teams from %s", len(store.records), path)
+	if env.Budgets.DefaultPrice == nil {
+		log.Printf("Warning: budgets.default_price is not set, requests to models without a price in budgets.prices are not counted against budgets")
+	}
+	return nil
+}
+
+// newUsageStore 创建空的用量存储
+func newUsageStore(path string) *usageStore {
+	return &usageStore{
+		path:     path,
+		records:  make(map[string]*UsageRecord),
+		unpriced: make(map[string]bool),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// validate 检查预算配置
+func (b BudgetConfig) validate() error {
+	switch b.Action {
+	case "", "reject":
+	case "downgrade":
+		if b.DowngradeModel == "" {
+			return errors.New("downgrade_model is required when action is downgrade")
+		}
+	default:
+		return fmt.Errorf("unknown budget action %q", b.Action)
+	}
+	return nil
+}
+
+// budgetPeriod 一个周期的预算
+type budgetPeriod struct {
+	name  string
+	limit float64
+}
+
+// limits 返回各周期的预算
+func (b BudgetConfig) limits() []budgetPeriod {
+	return []budgetPeriod{{"daily", b.DailyUSD}, {"monthly", b.MonthlyUSD}, {"total", b.TotalUSD}}
+}
+
+// budgetFor 返回虚拟密钥的预算
+func budgetFor(key *VirtualKey) *BudgetConfig {
+	if key.Budget != nil {
+		return key.Budget
+	}
+	return env.Budgets.PerKey
+}
+
+// modelPrice 查找上游模型的价格
+func modelPrice(target RouteTarget) (ModelPrice, bool) {
+	if target.Upstream != nil {
+		if price, ok := env.Budgets.Prices[target.Upstream.Name+":"+target.Model]; ok {
+			return price, true
+		}
+	}
+	if price, ok := env.Budgets.Prices[target.Model]; ok {
+		return price, true
+	}
+	if env.Budgets.DefaultPrice != nil {
+		return *env.Budgets.DefaultPrice, true
+	}
+	return ModelPrice{}, false
+}
+
+// current 返回按当前日期滚动后的用量
+func (r *UsageRecord) current(now time.Time) *UsageRecord {
+	day := now.UTC().Format("2006-01-02")
+	month := now.UTC().Format("2006-01")
+	if r.Daily.Period != day {
+		r.Daily = UsageCounter{Period: day}
+	}
+	if r.Monthly.Period != month {
+		r.Monthly = UsageCounter{Period: month}
+	}
+	return r
+}
+
+// spent 返回指定周期已花费的金额
+func (r *UsageRecord) spent(period string) float64 {
+	switch period {
+	case "daily":
+		return r.Daily.CostUSD
+	case "monthly":
+		return r.Monthly.CostUSD
+	}
+	return r.Total.CostUSD
+}
+
+// budgetSubject 参与预算检查的主体
+type budgetSubject struct {
+	key    string // 用量存储中的键
+	label  string // 用于提示信息
+	budget *BudgetConfig
+}
+
+// budgetSubjects 返回虚拟密钥及其团队的预算主体
+func budgetSubjects(key *VirtualKey) []budgetSubject {
+	subjects := []budgetSubject{{key: "key:" + key.ID, label: "key " + key.Name, budget: budgetFor(key)}}
+	if key.Team != "" {
+		subject := budgetSubject{key: "team:" + key.Team, label: "team " + key.Team}
+		if budget, ok := env.Budgets.Teams[key.Team]; ok {
+			subject.budget = &budget
+		}
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+// budgetDecision 预算检查结果
+type budgetDecision struct {
+	Exceeded       string   // 预算用完时的说明，为空表示未超出
+	DowngradeModel string   // 超出且配置了降级时改用的模型，为空时拒绝请求
+	Warnings       []string // 接近预算的警告
+}
+
+// Check 检查虚拟密钥及其团队的预算；多个预算用完时拒绝优先于降级
+func (s *usageStore) Check(client clientCredentials) budgetDecision {
+	var decision budgetDecision
+	if s == nil || client.VirtualKey == nil {
+		return decision
+	}
+	warnRatio := env.Budgets.WarnRatio
+	if warnRatio <= 0 {
+		warnRatio = 0.8
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var rejected, downgraded string
+	for _, subject := range budgetSubjects(client.VirtualKey) {
+		if subject.budget == nil {
+			continue
+		}
+		record := s.record(subject.key).current(now)
+		for _, period := range subject.budget.limits() {
+			if period.limit <= 0 {
+				continue
+			}
+			spent := record.spent(period.name)
+			if spent < period.limit {
+				if spent >= period.limit*warnRatio {
+					decision.Warnings = append(decision.Warnings, fmt.Sprintf("%s %s %.0f%% ($%.2f of $%.2f)", subject.label, period.name, spent/period.limit*100, spent, period.limit))
+				}
+				continue
+			}
+			message := fmt.Sprintf("%s has exhausted its %s budget ($%.2f of $%.2f)", subject.label, period.name, spent, period.limit)
+			if subject.budget.Action == "downgrade" {
+				if downgraded == "" {
+					downgraded = message
+					decision.DowngradeModel = subject.budget.DowngradeModel
+				}
+			} else if rejected == "" {
+				rejected = message
+			}
+		}
+	}
+	switch {
+	case rejected != "":
+		decision.Exceeded = rejected
+		decision.DowngradeModel = ""
+	case downgraded != "":
+		decision.Exceeded = downgraded
+	}
+	return decision
+}
+
+// Record 按实际用量和价格累计虚拟密钥及其团队的花费
+func (s *usageStore) Record(client clientCredentials, target RouteTarget, usage *AnthropicUsage) {
+	if s == nil || client.VirtualKey == nil || usage == nil {
+		return
+	}
+	price, priced := modelPrice(target)
+	cost := price.cost(usage)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !priced {
+		name := target.Model
+		if target.Upstream != nil {
+			name = target.Upstream.Name + ":" + name
+		}
+		if !s.unpriced[name] {
+			s.unpriced[name] = true
+			log.Printf("Warning: no price configured for %s, its usage is not counted against budgets", name)
+		}
+	}
+	now := time.Now()
+	for _, subject := range budgetSubjects(client.VirtualKey) {
+		record := s.record(subject.key).current(now)
+		for _, counter := range []*UsageCounter{&record.Daily, &record.Monthly, &record.Total} {
+			counter.Requests++
+			counter.InputTokens += int64(usage.InputTokens)
+			counter.OutputTokens += int64(usage.OutputTokens)
+			counter.CacheCreationInputTokens += int64(usage.CacheCreationInputTokens)
+			counter.CacheReadInputTokens += int64(usage.CacheReadInputTokens)
+			counter.CostUSD += cost
+		}
+	}
+	s.dirty = true
+}
+
+// Snapshot 返回所有用量的副本，可按键前缀过滤
+func (s *usageStore) Snapshot(prefix string) map[string]UsageRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	snapshot := make(map[string]UsageRecord)
+	for key, record := range s.records {
+		if strings.HasPrefix(key, prefix) {
+			snapshot[key] = *record.current(now)
+		}
+	}
+	return snapshot
+}
+
+// record 返回主体的用量记录，调用方需持有锁
+func (s *usageStore) record(key string) *UsageRecord {
+	record, ok := s.records[key]
+	if !ok {
+		record = &UsageRecord{}
+		s.records[key] = record
+	}
+	return record
+}
+
+// flushLoop 定期把用量原子地写回存储文件，直到Close
+func (s *usageStore) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(budgetFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Printf("Failed to save usage: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close 停止定期写回并保存剩余的用量，服务退出前调用
+func (s *usageStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
+
+// Flush 有未保存的用量时写回存储文件
+func (s *usageStore) Flush() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data, 0600); err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// budgetWarningHeader 合并预算警告
+func budgetWarningHeader(warnings []string) string {
+	sort.Strings(warnings)
+	return strings.Join(warnings, "; ")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTestBudgets 替换预算配置，测试结束后恢复
+func useTestBudgets(t *testing.T, settings BudgetSettings) {
+	t.Helper()
+	previous := env.Budgets
+	env.Budgets = settings
+	t.Cleanup(func() { env.Budgets = previous })
+}
+
+func TestModelPriceCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		price ModelPrice
+		usage AnthropicUsage
+		want  float64
+	}{
+		{"input and output", ModelPrice{Input: 3, Output: 15}, AnthropicUsage{InputTokens: 1000000, OutputTokens: 100000}, 4.5},
+		{"default cache prices", ModelPrice{Input: 10}, AnthropicUsage{CacheCreationInputTokens: 1000000, CacheReadInputTokens: 1000000}, 12.5 + 1},
+		{"explicit cache prices", ModelPrice{Input: 10, CacheWrite: 20, CacheRead: 2}, AnthropicUsage{CacheCreationInputTokens: 1000000, CacheReadInputTokens: 1000000}, 22},
+		{"no usage", ModelPrice{Input: 10, Output: 10}, AnthropicUsage{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.price.cost(&tt.usage); math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("cost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelPriceLookup(t *testing.T) {
+	upstream := &Upstream{Name: "openrouter"}
+	tests := []struct {
+		name         string
+		defaultPrice *ModelPrice
+		target       RouteTarget
+		want         ModelPrice
+		wantOK       bool
+	}{
+		{"upstream-qualified price wins", nil, RouteTarget{Upstream: upstream, Model: "opus"}, ModelPrice{Input: 15}, true},
+		{"model price", nil, RouteTarget{Upstream: &Upstream{Name: "other"}, Model: "opus"}, ModelPrice{Input: 10}, true},
+		{"unpriced without default", nil, RouteTarget{Upstream: upstream, Model: "unknown"}, ModelPrice{}, false},
+		{"unpriced uses default", &ModelPrice{Input: 99}, RouteTarget{Upstream: upstream, Model: "unknown"}, ModelPrice{Input: 99}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestBudgets(t, BudgetSettings{
+				Prices:       map[string]ModelPrice{"openrouter:opus": {Input: 15}, "opus": {Input: 10}},
+				DefaultPrice: tt.defaultPrice,
+			})
+			got, ok := modelPrice(tt.target)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("modelPrice = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUsageStoreBudget(t *testing.T) {
+	upstream := &Upstream{Name: "up"}
+	tests := []struct {
+		name          string
+		keyBudget     *BudgetConfig
+		teamBudget    *BudgetConfig
+		usage         AnthropicUsage // 每个请求的用量，价格为每百万token 1美元
+		requests      int
+		wantExceeded  bool
+		wantDowngrade string
+		wantWarnings  int
+	}{
+		{"under budget", &BudgetConfig{DailyUSD: 10}, nil, AnthropicUsage{InputTokens: 1000000}, 5, false, "", 0},
+		{"warning near budget", &BudgetConfig{DailyUSD: 10}, nil, AnthropicUsage{InputTokens: 1000000}, 9, false, "", 1},
+		{"cache tokens count against budget", &BudgetConfig{TotalUSD: 1}, nil, AnthropicUsage{CacheCreationInputTokens: 1000000}, 1, true, "", 0},
+		{"reject when exhausted", &BudgetConfig{MonthlyUSD: 2}, nil, AnthropicUsage{OutputTokens: 1000000}, 2, true, "", 0},
+		{"team downgrade", nil, &BudgetConfig{DailyUSD: 1, Action: "downgrade", DowngradeModel: "haiku"}, AnthropicUsage{InputTokens: 1000000}, 1, true, "haiku", 0},
+		{"reject wins over downgrade", &BudgetConfig{DailyUSD: 1}, &BudgetConfig{DailyUSD: 1, Action: "downgrade", DowngradeModel: "haiku"}, AnthropicUsage{InputTokens: 1000000}, 1, true, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := BudgetSettings{Enabled: true, Prices: map[string]ModelPrice{"m": {Input: 1, Output: 1, CacheWrite: 1}}}
+			if tt.teamBudget != nil {
+				settings.Teams = map[string]BudgetConfig{"research": *tt.teamBudget}
+			}
+			useTestBudgets(t, settings)
+			store := newUsageStore(filepath.Join(t.TempDir(), "usage.json"))
+			client := clientCredentials{VirtualKey: &VirtualKey{ID: "vk_1", Name: "ci", Team: "research", Budget: tt.keyBudget}}
+			for i := 0; i < tt.requests; i++ {
+				usage := tt.usage
+				store.Record(client, RouteTarget{Upstream: upstream, Model: "m"}, &usage)
+			}
+
+			decision := store.Check(client)
+			if (decision.Exceeded != "") != tt.wantExceeded || decision.DowngradeModel != tt.wantDowngrade || len(decision.Warnings) != tt.wantWarnings {
+				t.Fatalf("decision = %+v", decision)
+			}
+			team := store.Snapshot("team:research")["team:research"]
+			if team.Total.Requests != int64(tt.requests) || team.Total.CacheCreationInputTokens != int64(tt.usage.CacheCreationInputTokens*tt.requests) {
+				t.Fatalf("team usage = %+v", team.Total)
+			}
+		})
+	}
+}
+
+func TestUsageStoreCloseFlushes(t *testing.T) {
+	useTestBudgets(t, BudgetSettings{Enabled: true, Store: filepath.Join(t.TempDir(), "usage.json")})
+	if err := initBudgets(); err != nil {
+		t.Fatal(err)
+	}
+	store := spend
+	t.Cleanup(func() { spend = nil })
+
+	client := clientCredentials{VirtualKey: &VirtualKey{ID: "vk_1", Name: "ci"}}
+	store.Record(client, RouteTarget{Model: "unpriced"}, &AnthropicUsage{InputTokens: 7, CacheReadInputTokens: 3})
+	// 不等定期写回，Close应当立即保存
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(env.Budgets.Store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records map[string]UsageRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatal(err)
+	}
+	total := records["key:vk_1"].Total
+	if total.InputTokens != 7 || total.CacheReadInputTokens != 3 || total.CostUSD != 0 {
+		t.Fatalf("saved usage = %+v", total)
+	}
+}
+
+func TestUsageMeterCacheTokens(t *testing.T) {
+	stream := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"usage":{"input_tokens":5,"output_tokens":1,"cache_creation_input_tokens":100,"cache_read_input_tokens":200}}}` + "\n\n" +
+		"event: message_delta\n" +
+		`data: {"type":"message_delta","usage":{"output_tokens":42}}` + "\n\n"
+	meter := newUsageMeter(io.NopCloser(strings.NewReader(stream)))
+	buf := make([]byte, 7)
+	for {
+		if _, err := meter.Read(buf); err != nil {
+			break
+		}
+	}
+	want := AnthropicUsage{InputTokens: 5, OutputTokens: 42, CacheCreationInputTokens: 100, CacheReadInputTokens: 200}
+	if meter.Usage != want {
+		t.Fatalf("usage = %+v, want %+v", meter.Usage, want)
+	}
+}
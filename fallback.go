@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	errStreamingUnsupported = errors.New("streaming is not supported by the upstream")
+	errConvertRequest       = errors.New("failed to convert request format")
+)
+
+// UpstreamAttempt 一次上游尝试的记录
+type UpstreamAttempt struct {
+	Upstream   string `json:"upstream"`
+	Model      string `json:"model"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Retry      int    `json:"retry,omitempty"` // 同一目标的第几次重试
+	Key        string `json:"key,omitempty"`   // 使用的密钥池密钥名称
+}
+
+// upstreamResult 最终响应的上游及其请求
+type upstreamResult struct {
+	Target          RouteTarget
+	Request         *ProviderRequest
+	UpstreamRequest interface{}
+	Response        *http.Response
+	Attempts        []UpstreamAttempt
+}
+
+// shouldFallback 判断上游状态码是否应切换到下一个目标
+func shouldFallback(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// sendWithFallback 按路由目标顺序发送请求
+// 连接失败或返回429/5xx时尝试下一个目标；此时尚未向客户端写出任何数据。
+// 返回的Response可能是最后一个目标的错误响应，由调用方原样转发
+func sendWithFallback(ctx context.Context, route Route, anthropicRequest MessageCreateParamsBase, body []byte, header http.Header, client clientCredentials) (*upstreamResult, error) {
+	targets := route.Targets()
+	result := &upstreamResult{}
+	var lastErr error
+
+	for i, target := range targets {
+		last := i == len(targets)-1
+		provider := target.Upstream.Provider
+		attempt := UpstreamAttempt{Upstream: target.Upstream.Name, Model: target.Model}
+
+		apiKey, usePool, err := client.forUpstream(target.Upstream)
+		if err != nil {
+			lastErr = err
+			attempt.Error = err.Error()
+			result.Attempts = append(result.Attempts, attempt)
+			continue
+		}
+		providerRequest := &ProviderRequest{
+			Anthropic: anthropicRequest,
+			RawBody:   body,
+			Header:    header,
+			Model:     target.Model,
+			APIKey:    apiKey,
+		}
+		var pool *keyPool
+		if usePool {
+			pool = target.Upstream.Keys
+		}
+
+		if anthropicRequest.Stream && !provider.Capabilities(target.Model).Streaming {
+			lastErr = errStreamingUnsupported
+			attempt.Error = lastErr.Error()
+			result.Attempts = append(result.Attempts, attempt)
+			continue
+		}
+
+		upstreamRequest, err := provider.ConvertRequest(providerRequest)
+		if err != nil {
+			lastErr = fmt.Errorf("%w: %v", errConvertRequest, err)
+			attempt.Error = lastErr.Error()
+			result.Attempts = append(result.Attempts, attempt)
+			continue
+		}
+
+		resp, err := sendWithRetry(ctx, target, pool, providerRequest, upstreamRequest, result)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		if !last && shouldFallback(resp.StatusCode) {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+
+		result.Target = target
+		result.Request = providerRequest
+		result.UpstreamRequest = upstreamRequest
+		result.Response = resp
+		return result, nil
+	}
+
+	return result, lastErr
+}
+
+// sendWithRetry 按上游的重试策略向单个目标发送请求
+// 重试只发生在拿到响应头之前或响应为可重试状态码时，此时尚未向客户端写出任何数据。
+// pool不为nil时每次尝试从密钥池重新选择密钥，密钥被隔离后立即换用其他密钥，不占用重试次数。
+// 熔断器打开时不发送请求，直接返回errCircuitOpen由调用方切换备用目标
+func sendWithRetry(ctx context.Context, target RouteTarget, pool *keyPool, providerRequest *ProviderRequest, upstreamRequest interface{}, result *upstreamResult) (*http.Response, error) {
+	policy := target.Upstream.Retry
+	provider := target.Upstream.Provider
+	breaker := target.Upstream.breakerFor(target.Model)
+	keySwitches := 0
+
+	for retry := 0; ; {
+		attempt := UpstreamAttempt{Upstream: target.Upstream.Name, Model: target.Model, Retry: retry}
+
+		if breaker != nil && !breaker.Allow() {
+			attempt.Error = errCircuitOpen.Error()
+			result.Attempts = append(result.Attempts, attempt)
+			return nil, errCircuitOpen
+		}
+
+		var key *pooledKey
+		if pool != nil {
+			var err error
+			if keySwitches == 0 {
+				key, err = pool.AcquirePreferred(target.PreferredKey)
+			} else {
+				key, err = pool.Acquire()
+			}
+			if err != nil {
+				if breaker != nil {
+					breaker.Release()
+				}
+				attempt.Error = err.Error()
+				result.Attempts = append(result.Attempts, attempt)
+				return nil, err
+			}
+			providerRequest.APIKey = key.key
+			attempt.Key = key.name
+		}
+
+		start := time.Now()
+		resp, err := provider.Do(ctx, providerRequest, upstreamRequest)
+		latency := time.Since(start)
+		attempt.DurationMs = latency.Milliseconds()
+
+		if breaker != nil {
+			if ctx.Err() != nil {
+				breaker.Release()
+			} else if err != nil {
+				breaker.Record(true, latency)
+			} else {
+				breaker.Record(breakerFailure(resp.StatusCode, nil), latency)
+			}
+		}
+
+		keyRejected := false
+		if key != nil {
+			keyRejected = pool.Report(key, resp, err)
+			if err == nil {
+				resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: func() { pool.Release(key) }}
+			} else {
+				pool.Release(key)
+			}
+		}
+		canRetry := retry+1 < policy.maxAttempts && ctx.Err() == nil
+
+		if err != nil {
+			attempt.Error = err.Error()
+			result.Attempts = append(result.Attempts, attempt)
+			if !canRetry || !sleepContext(ctx, policy.backoff(retry+1)) {
+				return nil, err
+			}
+			retry++
+			continue
+		}
+		attempt.StatusCode = resp.StatusCode
+		result.Attempts = append(result.Attempts, attempt)
+
+		if keyRejected && keySwitches < pool.Size()-1 && pool.HasAvailable() && ctx.Err() == nil {
+			keySwitches++
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+
+		if !canRetry || !policy.retryableStatus[resp.StatusCode] {
+			return resp, nil
+		}
+		delay, ok := policy.retryDelay(resp, retry+1)
+		if !ok {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if !sleepContext(ctx, delay) {
+			return nil, ctx.Err()
+		}
+		retry++
+	}
+}
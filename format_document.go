@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// DocumentSource 定义document/image块的来源
+type DocumentSource struct {
+	Type      string      `json:"type"`
+	MediaType string      `json:"media_type,omitempty"`
+	Data      string      `json:"data,omitempty"`
+	URL       string      `json:"url,omitempty"`
+	Content   interface{} `json:"content,omitempty"`
+}
+
+// OpenAIContentPart OpenAI多部分消息内容
+type OpenAIContentPart struct {
+	Type string          `json:"type"`
+	Text string          `json:"text,omitempty"`
+	File *OpenAIFilePart `json:"file,omitempty"`
+}
+
+// OpenAIFilePart OpenAI文件内容
+type OpenAIFilePart struct {
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data"`
+}
+
+// modelSupportsFileInput 判断映射后的模型是否支持file内容部分
+func modelSupportsFileInput(model string) bool {
+	for _, keyword := range env.FileInputModels {
+		if strings.Contains(model, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertDocumentBlock 将Anthropic document块转换为OpenAI内容部分
+// 模型支持文件输入时PDF作为file部分传递，否则在本地提取文本并加上页码标记内联
+// citations配置在转换时被忽略
+func convertDocumentBlock(part ContentPart, allowFile bool) OpenAIContentPart {
+	if part.Source == nil {
+		return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, "")}
+	}
+
+	switch part.Source.Type {
+	case "base64":
+		if part.Source.MediaType == "application/pdf" && allowFile {
+			filename := part.Title
+			if filename == "" {
+				filename = "document.pdf"
+			}
+			return OpenAIContentPart{
+				Type: "file",
+				File: &OpenAIFilePart{
+					Filename: filename,
+					FileData: "data:application/pdf;base64," + part.Source.Data,
+				},
+			}
+		}
+
+		data, err := base64.StdEncoding.DecodeString(part.Source.Data)
+		if err != nil {
+			return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, "[document could not be decoded]")}
+		}
+		if part.Source.MediaType != "application/pdf" {
+			// 只有文本内容可以内联，二进制文件放进提示词只会是乱码
+			if !utf8.Valid(data) {
+				return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, fmt.Sprintf("[binary %s document omitted]", part.Source.MediaType))}
+			}
+			return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, string(data))}
+		}
+		pages, err := extractPDFText(data)
+		if err != nil {
+			log.Printf("Failed to extract PDF text: %v", err)
+			return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, "[PDF text could not be extracted]")}
+		}
+		return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, pages, "")}
+	case "text":
+		return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, part.Source.Data)}
+	case "content":
+		if contentStr, ok := part.Source.Content.(string); ok {
+			return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, contentStr)}
+		}
+		var texts []string
+		if contentArray, ok := part.Source.Content.([]interface{}); ok {
+			for _, item := range contentArray {
+				itemBytes, _ := json.Marshal(item)
+				var block ContentPart
+				if err := json.Unmarshal(itemBytes, &block); err == nil && block.Type == "text" {
+					texts = append(texts, block.Text)
+				}
+			}
+		}
+		return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, strings.Join(texts, "\n"))}
+	default:
+		return OpenAIContentPart{Type: "text", Text: formatDocumentText(part, nil, fmt.Sprintf("[unsupported document source: %s]", part.Source.Type))}
+	}
+}
+
+// formatDocumentText 将文档内容格式化为内联文本，pages非空时为每页加上页码标记
+func formatDocumentText(part ContentPart, pages []string, body string) string {
+	var sb strings.Builder
+	if part.Title != "" {
+		sb.WriteString(fmt.Sprintf("<document title=%q>\n", part.Title))
+	} else {
+		sb.WriteString("<document>\n")
+	}
+	if part.Context != "" {
+		sb.WriteString(part.Context + "\n")
+	}
+	if pages != nil {
+		for i, page := range pages {
+			sb.WriteString(fmt.Sprintf("--- Page %d ---\n", i+1))
+			if page != "" {
+				sb.WriteString(page + "\n")
+			}
+		}
+	} else if body != "" {
+		sb.WriteString(strings.TrimRight(body, "\n") + "\n")
+	}
+	sb.WriteString("</document>")
+	return sb.String()
+}
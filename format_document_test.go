@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestConvertDocumentBlock(t *testing.T) {
+	encode := func(data []byte) string { return base64.StdEncoding.EncodeToString(data) }
+	tests := []struct {
+		name      string
+		source    DocumentSource
+		allowFile bool
+		wantType  string
+		want      string
+		notWant   string
+	}{
+		{"text document inlined", DocumentSource{Type: "base64", MediaType: "text/csv", Data: encode([]byte("a,b\n1,2\n"))}, false, "text", "a,b\n1,2", ""},
+		{"utf-8 text inlined", DocumentSource{Type: "base64", MediaType: "text/plain", Data: encode([]byte("héllo 世界"))}, false, "text", "héllo 世界", ""},
+		{"binary document replaced by placeholder", DocumentSource{Type: "base64", MediaType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Data: encode([]byte{0x50, 0x4b, 0x03, 0x04, 0xff, 0xfe, 0x00})}, false, "text", "[binary application/vnd.openxmlformats-officedocument.wordprocessingml.document document omitted]", "\xff"},
+		{"undecodable base64", DocumentSource{Type: "base64", MediaType: "text/plain", Data: "%%%"}, false, "text", "[document could not be decoded]", ""},
+		{"pdf passed as file", DocumentSource{Type: "base64", MediaType: "application/pdf", Data: encode(simplePDF(pdfStream("", []byte("BT (Hi) Tj ET"))))}, true, "file", "", ""},
+		{"pdf text extracted", DocumentSource{Type: "base64", MediaType: "application/pdf", Data: encode(simplePDF(pdfStream("", []byte("BT (Hi) Tj ET"))))}, false, "text", "--- Page 1 ---\nHi", ""},
+		{"plain text source", DocumentSource{Type: "text", MediaType: "text/plain", Data: "notes"}, false, "text", "notes", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := tt.source
+			part := convertDocumentBlock(ContentPart{Type: "document", Source: &source}, tt.allowFile)
+			if part.Type != tt.wantType {
+				t.Fatalf("type = %q, want %q", part.Type, tt.wantType)
+			}
+			if tt.wantType == "file" {
+				if part.File == nil || !strings.HasPrefix(part.File.FileData, "data:application/pdf;base64,") {
+					t.Fatalf("file = %+v", part.File)
+				}
+				return
+			}
+			if !strings.Contains(part.Text, tt.want) {
+				t.Fatalf("text = %q, want it to contain %q", part.Text, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(part.Text, tt.notWant) {
+				t.Fatalf("text = %q, want binary data left out", part.Text)
+			}
+		})
+	}
+}
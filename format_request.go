@@ -13,6 +13,30 @@ type MessageCreateParamsBase struct {
 	Temperature *float64    `json:"temperature,omitempty"`
 	Tools       []Tool      `json:"tools,omitempty"`
 	Stream      bool        `json:"stream,omitempty"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          *int            `json:"top_k,omitempty"`
+	Thinking      *ThinkingConfig `json:"thinking,omitempty"`
+	ToolChoice    *ToolChoice     `json:"tool_choice,omitempty"`
+	Metadata      *RequestMetadata `json:"metadata,omitempty"`
+}
+
+// RequestMetadata 定义请求元数据
+type RequestMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+// ThinkingConfig 定义扩展思考配置
+type ThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// ToolChoice 定义工具选择策略
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
 }
 
 // Message 定义消息结构
@@ -23,6 +47,7 @@ type Message struct {
 
 // Tool 定义工具结构
 type Tool struct {
+	Type        string                 `json:"type,omitempty"` // 服务端工具类型，如 web_search_20250305
 	Name        string                 `json:"name"`
 	Description string                 `json:"description"`
 	InputSchema map[string]interface{} `json:"input_schema"`
@@ -37,6 +62,14 @@ type ContentPart struct {
 	Input     map[string]interface{} `json:"input,omitempty"`
 	ToolUseID string                 `json:"tool_use_id,omitempty"`
 	Content   interface{}            `json:"content,omitempty"`
+	Source    *DocumentSource        `json:"source,omitempty"`
+	Title     string                 `json:"title,omitempty"`
+	Context   string                 `json:"context,omitempty"`
+	Citations interface{}            `json:"citations,omitempty"`
+	IsError   bool                   `json:"is_error,omitempty"`
+	Thinking  string                 `json:"thinking,omitempty"`
+	Signature string                 `json:"signature,omitempty"`
+	Data      string                 `json:"data,omitempty"`
 }
 
 // SystemMessage 定义系统消息结构
@@ -72,9 +105,16 @@ type OpenAIRequest struct {
 	Messages    []OpenAIMessage `json:"messages"`
 	Temperature *float64       `json:"temperature,omitempty"`
 	Stream      bool           `json:"stream,omitempty"`
+	// StreamOptions 流式请求时要求在最后一个chunk中返回usage，否则无法计量用量
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
 	Tools       []OpenAITool   `json:"tools,omitempty"`
 }
 
+// OpenAIStreamOptions OpenAI流式请求选项
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
 // OpenAITool OpenAI工具格式
 type OpenAITool struct {
 	Type     string               `json:"type"`
@@ -88,23 +128,6 @@ type OpenAIFunctionTool struct {
 	Parameters  map[string]interface{} `json:"parameters"`
 }
 
-// mapModel 映射模型名称
-func mapModel(anthropicModel string) string {
-	// 如果模型已经包含'/'，则是OpenRouter模型ID - 直接返回
-	if strings.Contains(anthropicModel, "/") {
-		return anthropicModel
-	}
-	
-	// 遍历配置中的映射规则
-	for keyword, mappedModel := range env.ModelMappings {
-		if strings.Contains(anthropicModel, keyword) {
-			return mappedModel
-		}
-	}
-	
-	return anthropicModel
-}
-
 // validateOpenAIToolCalls 验证OpenAI格式的消息以确保完整的tool_calls/tool消息配对
 func validateOpenAIToolCalls(messages []OpenAIMessage) []OpenAIMessage {
 	var validatedMessages []OpenAIMessage
@@ -196,9 +219,10 @@ func validateOpenAIToolCalls(messages []OpenAIMessage) []OpenAIMessage {
 	return validatedMessages
 }
 
-// formatAnthropicToOpenAI 将Anthropic格式转换为OpenAI格式
-func formatAnthropicToOpenAI(body MessageCreateParamsBase) (OpenAIRequest, error) {
+// formatAnthropicToOpenAI 将Anthropic格式转换为OpenAI格式，model为映射后的上游模型
+func formatAnthropicToOpenAI(body MessageCreateParamsBase, model string, caps ProviderCapabilities) (OpenAIRequest, error) {
 	var openAIMessages []OpenAIMessage
+	allowFile := caps.FileInput
 	
 	// 转换消息
 	for _, anthropicMessage := range body.Messages {
@@ -250,6 +274,7 @@ func formatAnthropicToOpenAI(body MessageCreateParamsBase) (OpenAIRequest, error
 			}
 		} else if anthropicMessage.Role == "user" {
 			var userTextMessageContent string
+			var fileParts []OpenAIContentPart
 			var subsequentToolMessages []OpenAIMessage
 			
 			if contentStr, ok := anthropicMessage.Content.(string); ok {
@@ -263,6 +288,13 @@ func formatAnthropicToOpenAI(body MessageCreateParamsBase) (OpenAIRequest, error
 					if err := json.Unmarshal(contentBytes, &contentPart); err == nil {
 						if contentPart.Type == "text" {
 							userTextMessageContent += contentPart.Text + "\n"
+						} else if contentPart.Type == "document" {
+							documentPart := convertDocumentBlock(contentPart, allowFile)
+							if documentPart.Type == "file" {
+								fileParts = append(fileParts, documentPart)
+							} else {
+								userTextMessageContent += documentPart.Text + "\n"
+							}
 						} else if contentPart.Type == "tool_result" {
 							var toolContent interface{}
 							if contentStr, ok := contentPart.Content.(string); ok {
@@ -282,7 +314,17 @@ func formatAnthropicToOpenAI(body MessageCreateParamsBase) (OpenAIRequest, error
 			}
 			
 			trimmedUserText := strings.TrimSpace(userTextMessageContent)
-			if trimmedUserText != "" {
+			if len(fileParts) > 0 {
+				// 文件部分需要使用多部分内容格式
+				userParts := fileParts
+				if trimmedUserText != "" {
+					userParts = append(userParts, OpenAIContentPart{Type: "text", Text: trimmedUserText})
+				}
+				openAIMessages = append(openAIMessages, OpenAIMessage{
+					Role:    "user",
+					Content: userParts,
+				})
+			} else if trimmedUserText != "" {
 				openAIMessages = append(openAIMessages, OpenAIMessage{
 					Role:    "user",
 					Content: trimmedUserText,
@@ -332,14 +374,17 @@ func formatAnthropicToOpenAI(body MessageCreateParamsBase) (OpenAIRequest, error
 	
 	// 构建最终请求
 	data := OpenAIRequest{
-		Model:       mapModel(body.Model),
+		Model:       model,
 		Messages:    append(systemMessages, validateOpenAIToolCalls(openAIMessages)...),
 		Temperature: body.Temperature,
 		Stream:      body.Stream,
 	}
+	if body.Stream {
+		data.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
+	}
 	
 	// 处理工具
-	if len(body.Tools) > 0 {
+	if len(body.Tools) > 0 && caps.Tools {
 		var tools []OpenAITool
 		for _, item := range body.Tools {
 			tools = append(tools, OpenAITool{
@@ -355,4 +400,40 @@ func formatAnthropicToOpenAI(body MessageCreateParamsBase) (OpenAIRequest, error
 	}
 	
 	return data, nil
-}
\ No newline at end of file
+}
+// contentBlocks 将消息内容统一解析为内容块列表，字符串内容视为单个text块
+func contentBlocks(content interface{}) []ContentPart {
+	if contentStr, ok := content.(string); ok {
+		return []ContentPart{{Type: "text", Text: contentStr}}
+	}
+	var blocks []ContentPart
+	if contentArray, ok := content.([]interface{}); ok {
+		for _, contentItem := range contentArray {
+			contentBytes, _ := json.Marshal(contentItem)
+			var contentPart ContentPart
+			if err := json.Unmarshal(contentBytes, &contentPart); err == nil {
+				blocks = append(blocks, contentPart)
+			}
+		}
+	}
+	return blocks
+}
+
+// contentText 拼接内容中的所有文本块
+func contentText(content interface{}) string {
+	var texts []string
+	for _, block := range contentBlocks(content) {
+		if block.Type == "text" && block.Text != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// systemPromptText 将system字段(字符串或text块数组)拼接为纯文本
+func systemPromptText(system interface{}) string {
+	if system == nil {
+		return ""
+	}
+	return contentText(system)
+}
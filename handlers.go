@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -77,16 +79,6 @@ func handleMessages(c *gin.Context) {
 	// 记录Anthropic请求
 	dataLogger.LogAnthropicRequest(requestID, anthropicRequest)
 
-	// 转换为OpenAI格式
-	openaiRequest, err := formatAnthropicToOpenAI(anthropicRequest)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert request format"})
-		return
-	}
-
-	// 记录OpenAI请求
-	dataLogger.LogOpenAIRequest(requestID, openaiRequest)
-
 	// 获取API密钥
 	bearerToken := c.GetHeader("X-Api-Key")
 	if bearerToken == "" {
@@ -101,41 +93,188 @@ func handleMessages(c *gin.Context) {
 		return
 	}
 
-	// 准备OpenAI请求
-	requestBody, err := json.Marshal(openaiRequest)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal OpenAI request"})
-		return
+	// 启用虚拟密钥时客户端只持有路由器签发的密钥，真实的上游密钥不离开服务端
+	client := clientCredentials{APIKey: bearerToken}
+	if virtualKeys != nil {
+		allowClientKey := env.VirtualKeys.AllowClientKeys && !strings.HasPrefix(bearerToken, virtualKeyPrefix)
+		if !allowClientKey {
+			virtualKey, err := virtualKeys.Authenticate(bearerToken)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"type": "error",
+					"error": gin.H{
+						"type":    "authentication_error",
+						"message": err.Error(),
+					},
+				})
+				return
+			}
+			client = clientCredentials{VirtualKey: virtualKey}
+			dataLogger.LogVirtualKey(requestID, virtualKey.ID)
+		}
 	}
 
-	// 发送请求到OpenRouter
-	req, err := http.NewRequest("POST", env.OpenRouterBaseUrl+"/chat/completions", bytes.NewBuffer(requestBody))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+	// 在转换请求之前按密钥策略检查模型和参数，并加上强制的system前缀
+	if client.VirtualKey != nil && client.VirtualKey.Policy != nil {
+		policy := client.VirtualKey.Policy
+		if err := policy.Check(anthropicRequest); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "permission_error",
+					"message": err.Error(),
+				},
+			})
+			return
+		}
+		if body, err = policy.Apply(&anthropicRequest, body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to apply key policy"})
+			return
+		}
+	}
+
+	// 按密钥和客户端IP限流，拒绝时返回Anthropic格式的rate_limit_error，客户端可以据此退避
+	lease, rejection := rateLimits.Admit(rateSubjects(client, c.ClientIP()), estimateInputTokens(anthropicRequest), anthropicRequest.Stream)
+	if rejection != nil {
+		for name := range rejection.headers {
+			c.Header(name, rejection.headers.Get(name))
+		}
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    "rate_limit_error",
+				"message": rejection.Error(),
+			},
+		})
 		return
 	}
+	// 请求结束时按实际用量结算限流；没有拿到用量时保持估算值
+	var finalUsage *AnthropicUsage
+	var usedTarget RouteTarget
+	defer func() {
+		lease.Finish(finalUsage)
+		spend.Record(client, usedTarget, finalUsage)
+	}()
+	if lease != nil {
+		for name := range lease.Headers {
+			c.Header(name, lease.Headers.Get(name))
+		}
+	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+bearerToken)
+	// 预算用完时拒绝请求或改用更便宜的模型，接近预算时在响应头中警告
+	budget := spend.Check(client)
+	if len(budget.Warnings) > 0 {
+		c.Header("X-Router-Budget-Warning", budgetWarningHeader(budget.Warnings))
+	}
+	requestedModel := anthropicRequest.Model
+	if budget.Exceeded != "" {
+		if budget.DowngradeModel == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "invalid_request_error",
+					"message": "Budget exceeded: " + budget.Exceeded,
+				},
+			})
+			return
+		}
+		requestedModel = budget.DowngradeModel
+		c.Header("X-Router-Budget-Downgraded", anthropicRequest.Model+" -> "+requestedModel)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// 选择上游，失败时按备用目标依次尝试，配置了对冲时并行发出重复请求
+	route := resolveRoute(requestedModel, extractRequestFeatures(anthropicRequest, c.Request.Header, client.ID()))
+	// 映射规则可能把允许的模型改写为其他上游模型，按密钥策略再检查一次实际目标
+	if client.VirtualKey != nil && client.VirtualKey.Policy != nil {
+		if route, err = client.VirtualKey.Policy.Restrict(route); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "permission_error",
+					"message": err.Error(),
+				},
+			})
+			return
+		}
+	}
+	// 同一会话尽量保持在同一上游和密钥上，以命中上游的提示词缓存
+	affinityKey := conversationAffinityKey(anthropicRequest, client.ID())
+	route = cacheAffinity.Apply(route, affinityKey)
+	startMirror(requestID, route, anthropicRequest, body, c.Request.Header, client)
+	result, hedgeLog, err := sendWithHedge(c.Request.Context(), route, anthropicRequest, body, c.Request.Header, client)
+	dataLogger.LogUpstream(requestID, result.Target.Upstream, result.Target.Model, result.Attempts)
+	dataLogger.LogHedge(requestID, hedgeLog)
+	if route.Match != nil && route.Match.Variant != "" {
+		dataLogger.LogVariant(requestID, route.Match.Variant)
+		c.Header("X-Router-Variant", route.Match.Variant)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send request to upstream"})
+		switch {
+		case errors.Is(err, errVirtualKeyRequired):
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "authentication_error",
+					"message": "This upstream uses server-side credentials; authenticate with a virtual key issued by this router",
+				},
+			})
+		case errors.Is(err, errNoUpstreamCredential):
+			c.JSON(http.StatusForbidden, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "permission_error",
+					"message": "No credential is configured for the routed upstream; add one to this virtual key's credentials or to the upstream",
+				},
+			})
+		case errors.Is(err, errStreamingUnsupported):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Streaming is not supported by the upstream"})
+		case errors.Is(err, errCircuitOpen):
+			// 所有目标都已熔断，快速返回而不是等待上游超时
+			c.JSON(529, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "overloaded_error",
+					"message": "Upstream is temporarily unavailable",
+				},
+			})
+		case errors.Is(err, errConvertRequest):
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert request format"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send request to upstream"})
+		}
 		return
 	}
+	if result.Response.StatusCode == http.StatusOK {
+		cacheAffinity.Remember(affinityKey, result)
+	}
+	usedTarget = result.Target
+	provider := result.Target.Upstream.Provider
+	providerRequest := result.Request
+	resp := result.Response
 	defer resp.Body.Close()
 
+	// 记录上游请求
+	dataLogger.LogOpenAIRequest(requestID, result.UpstreamRequest)
+
+	// 标记实际响应的上游
+	c.Header("X-Router-Upstream", result.Target.Upstream.Name)
+	c.Header("X-Router-Model", result.Target.Model)
+
 	// 处理错误响应
 	if resp.StatusCode != http.StatusOK {
 		errorBody, _ := io.ReadAll(resp.Body)
-		c.Data(resp.StatusCode, "text/plain", errorBody)
+		contentType := resp.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+		c.Data(resp.StatusCode, contentType, errorBody)
 		return
 	}
 
 	// 处理流式响应
-	if openaiRequest.Stream {
-		anthropicStream := streamOpenAIToAnthropic(resp.Body, openaiRequest.Model)
+	if anthropicRequest.Stream {
+		meter := newUsageMeter(provider.ConvertStream(resp, providerRequest))
+		var anthropicStream io.ReadCloser = meter
 
 		// 如果启用了日志记录，包装流以收集完整数据
 		if dataLogger.enabled && dataLogger.config.LogAnthropicResponse {
@@ -147,23 +286,97 @@ func handleMessages(c *gin.Context) {
 		c.Header("Connection", "keep-alive")
 		io.Copy(c.Writer, anthropicStream)
 		anthropicStream.Close()
+		finalUsage = &meter.Usage
 	} else {
 		// 处理非流式响应
-		var openaiResponse OpenAICompletionResponse
-		if err := json.NewDecoder(resp.Body).Decode(&openaiResponse); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode OpenAI response"})
+		upstreamResponse, anthropicResponse, err := provider.ConvertResponse(resp, providerRequest)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode upstream response"})
 			return
 		}
 
-		// 记录OpenAI响应
-		dataLogger.LogOpenAIResponse(requestID, openaiResponse)
-
-		// 转换为Anthropic格式
-		anthropicResponse := formatOpenAIToAnthropic(openaiResponse, openaiRequest.Model)
+		// 记录上游响应
+		dataLogger.LogOpenAIResponse(requestID, upstreamResponse)
 
 		// 记录Anthropic响应
 		dataLogger.LogAnthropicResponse(requestID, anthropicResponse)
+		usage := responseUsage(anthropicResponse)
+		finalUsage = &usage
 
 		c.JSON(http.StatusOK, anthropicResponse)
 	}
-}
\ No newline at end of file
+}
+
+// handleDebugRoute 显示指定模型命中的映射规则和上游
+func handleDebugRoute(c *gin.Context) {
+	model := c.Query("model")
+	if model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model query parameter is required"})
+		return
+	}
+
+	// 请求特征可以通过查询参数模拟：thinking、input_tokens、tools(逗号分隔)、anthropic_beta、system、user_id、api_key
+	features := &requestFeatures{
+		Thinking:      c.Query("thinking") == "true",
+		AnthropicBeta: c.Query("anthropic_beta"),
+		System:        c.Query("system"),
+		UserID:        c.Query("user_id"),
+		APIKey:        c.Query("api_key"),
+	}
+	features.InputTokens, _ = strconv.Atoi(c.Query("input_tokens"))
+	if tools := c.Query("tools"); tools != "" {
+		for _, name := range strings.Split(tools, ",") {
+			features.Tools = append(features.Tools, Tool{Name: name, Type: name})
+		}
+	}
+
+	route := resolveRoute(model, features)
+	result := gin.H{
+		"model":          model,
+		"upstream":       route.Upstream.Name,
+		"upstream_type":  route.Upstream.Provider.Name(),
+		"upstream_model": route.Model,
+		"passthrough":    route.Passthrough,
+		"rule":           nil,
+	}
+	var fallbacks []gin.H
+	for _, target := range route.Fallbacks {
+		fallbacks = append(fallbacks, gin.H{"upstream": target.Upstream.Name, "model": target.Model})
+	}
+	if len(fallbacks) > 0 {
+		result["fallbacks"] = fallbacks
+	}
+	if route.Mirror != nil {
+		mirrorUpstream, mirrorModel := splitUpstreamTarget(route.Mirror.Target)
+		result["mirror"] = gin.H{"upstream": mirrorUpstream.Name, "model": mirrorModel, "sample_rate": route.Mirror.SampleRate}
+	}
+	if route.Hedge != nil {
+		hedgeUpstream, hedgeModel := splitUpstreamTarget(route.Hedge.Target)
+		result["hedge"] = gin.H{"upstream": hedgeUpstream.Name, "model": hedgeModel, "delay_ms": route.Hedge.Delay.Milliseconds()}
+	}
+	if route.Match != nil {
+		rule := gin.H{
+			"match":  route.Match.Rule.Match,
+			"target": route.Match.Rule.Target,
+			"index":  route.Match.Index,
+			"source": "model_rules",
+		}
+		if route.Match.Rule.Pattern != "" {
+			rule["pattern"] = route.Match.Rule.Pattern
+		}
+		if route.Match.Variant != "" {
+			rule["variant"] = route.Match.Variant
+		}
+		if route.Match.Rule.When != nil {
+			rule["when"] = route.Match.Rule.When
+		}
+		if route.Match.Rule.legacy {
+			rule["source"] = "model_mappings"
+		}
+		if route.Passthrough {
+			rule["source"] = "passthrough.model_mappings"
+		}
+		result["rule"] = rule
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// HedgeConfig 对冲请求配置：主请求在DelayMs内没有产生首个数据时向Target发送重复请求
+type HedgeConfig struct {
+	DelayMs int    `json:"delay_ms"`
+	Target  string `json:"target,omitempty"` // "upstream:model"，为空时使用主目标(配置了密钥池时会选择另一个密钥)
+}
+
+// RouteHedge 解析后的对冲目标
+type RouteHedge struct {
+	Target string
+	Delay  time.Duration
+}
+
+// HedgeLog 对冲请求记录
+type HedgeLog struct {
+	DelayMs int64  `json:"delay_ms"`
+	Winner  string `json:"winner"` // primary 或 hedge
+	// 被取消的请求，其输入token已经发送给上游，按估算值记为重复成本
+	CancelledUpstream     string `json:"cancelled_upstream,omitempty"`
+	CancelledModel        string `json:"cancelled_model,omitempty"`
+	DuplicatedInputTokens int    `json:"duplicated_input_tokens,omitempty"`
+}
+
+// hedgeOutcome 一路请求的结果
+type hedgeOutcome struct {
+	result *upstreamResult
+	err    error
+	cancel context.CancelFunc
+	hedge  bool
+}
+
+// ok 判断该路请求是否成功拿到首个数据
+func (o hedgeOutcome) ok() bool {
+	return o.err == nil && o.result.Response.StatusCode == http.StatusOK
+}
+
+// cancelOnClose 响应体关闭时取消对应请求的上下文
+type cancelOnClose struct {
+	io.Reader
+	closer io.Closer
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.closer.Close()
+	c.cancel()
+	return err
+}
+
+// waitFirstData 读取响应体直到出现第一行数据，跳过SSE注释等保活行；已读内容会重新拼接到响应体前面
+func waitFirstData(resp *http.Response) error {
+	reader := bufio.NewReader(resp.Body)
+	var buffered bytes.Buffer
+	for {
+		line, err := reader.ReadBytes('\n')
+		buffered.Write(line)
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) > 0 && trimmed[0] != ':' {
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&buffered, reader), resp.Body}
+	return nil
+}
+
+// sendWithHedge 发送请求，路由配置了对冲时在主请求迟迟没有首个数据后发出重复请求，先产生数据的一方胜出，另一方被取消
+func sendWithHedge(ctx context.Context, route Route, anthropicRequest MessageCreateParamsBase, body []byte, header http.Header, client clientCredentials) (*upstreamResult, *HedgeLog, error) {
+	if route.Hedge == nil {
+		result, err := sendWithFallback(ctx, route, anthropicRequest, body, header, client)
+		return result, nil, err
+	}
+
+	outcomes := make(chan hedgeOutcome, 2)
+	launch := func(r Route, hedge bool) context.CancelFunc {
+		requestCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			var result *upstreamResult
+			var err error
+			// 不在处理请求的goroutine中，gin的Recovery无法捕获，适配器panic时按该路请求失败处理
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("Hedged request panicked: %v\n%s", p, debug.Stack())
+					if result != nil && result.Response != nil {
+						result.Response.Body.Close()
+					}
+					result, err = &upstreamResult{}, fmt.Errorf("upstream request panicked: %v", p)
+				}
+				outcomes <- hedgeOutcome{result: result, err: err, cancel: cancel, hedge: hedge}
+			}()
+			result, err = sendWithFallback(requestCtx, r, anthropicRequest, body, header, client)
+			if err == nil && result.Response.StatusCode == http.StatusOK {
+				if peekErr := waitFirstData(result.Response); peekErr != nil {
+					result.Response.Body.Close()
+					err = peekErr
+				}
+			}
+		}()
+		return cancel
+	}
+
+	// 对冲请求只发往对冲目标，不再走备用链
+	hedgeRoute := Route{}
+	hedgeRoute.Upstream, hedgeRoute.Model = splitUpstreamTarget(route.Hedge.Target)
+
+	cancels := []context.CancelFunc{launch(route, false)}
+	pending := 1
+	timer := time.NewTimer(route.Hedge.Delay)
+	defer timer.Stop()
+
+	var first *hedgeOutcome
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if len(cancels) == 1 {
+				cancels = append(cancels, launch(hedgeRoute, true))
+				pending++
+			}
+		case outcome := <-outcomes:
+			pending--
+			if outcome.ok() {
+				if first != nil {
+					closeOutcome(*first)
+				}
+				outcome.result.Response.Body = &cancelOnClose{
+					Reader: outcome.result.Response.Body,
+					closer: outcome.result.Response.Body,
+					cancel: outcome.cancel,
+				}
+				return outcome.result, finishHedge(route, hedgeRoute, outcome, cancels, outcomes, pending, anthropicRequest), nil
+			}
+			if first == nil || (!outcome.hedge && outcome.result.Response != nil) {
+				if first != nil {
+					closeOutcome(*first)
+				}
+				first = &outcome
+			} else {
+				closeOutcome(outcome)
+			}
+			// 主请求在对冲之前就失败时不再发出对冲，失败已经过了重试和备用链
+			if len(cancels) == 1 {
+				pending = 0
+			}
+		case <-ctx.Done():
+			for _, cancel := range cancels {
+				cancel()
+			}
+			go drainOutcomes(outcomes, pending)
+			return &upstreamResult{}, nil, ctx.Err()
+		}
+	}
+
+	// 两路都失败时返回主请求的结果，由调用方转发错误
+	var hedgeLog *HedgeLog
+	if len(cancels) > 1 {
+		hedgeLog = &HedgeLog{DelayMs: route.Hedge.Delay.Milliseconds(), Winner: "none"}
+	}
+	if first.result.Response != nil {
+		first.result.Response.Body = &cancelOnClose{
+			Reader: first.result.Response.Body,
+			closer: first.result.Response.Body,
+			cancel: first.cancel,
+		}
+	} else {
+		first.cancel()
+	}
+	return first.result, hedgeLog, first.err
+}
+
+// finishHedge 取消落败的请求并生成对冲记录
+func finishHedge(route, hedgeRoute Route, winner hedgeOutcome, cancels []context.CancelFunc, outcomes chan hedgeOutcome, pending int, anthropicRequest MessageCreateParamsBase) *HedgeLog {
+	if len(cancels) == 1 {
+		return nil
+	}
+	hedgeLog := &HedgeLog{DelayMs: route.Hedge.Delay.Milliseconds(), Winner: "primary"}
+	loser := hedgeRoute
+	if winner.hedge {
+		hedgeLog.Winner = "hedge"
+		loser = route
+	}
+	if pending > 0 {
+		for i, cancel := range cancels {
+			if (i == 1) != winner.hedge {
+				cancel()
+			}
+		}
+		go drainOutcomes(outcomes, pending)
+		hedgeLog.CancelledUpstream = loser.Upstream.Name
+		hedgeLog.CancelledModel = loser.Model
+		hedgeLog.DuplicatedInputTokens = estimateInputTokens(anthropicRequest)
+	}
+	return hedgeLog
+}
+
+// closeOutcome 关闭失败一方的响应并释放上下文
+func closeOutcome(outcome hedgeOutcome) {
+	if outcome.result != nil && outcome.result.Response != nil {
+		outcome.result.Response.Body.Close()
+	}
+	outcome.cancel()
+}
+
+// drainOutcomes 在后台等待已取消的请求返回并释放资源
+func drainOutcomes(outcomes chan hedgeOutcome, pending int) {
+	for i := 0; i < pending; i++ {
+		closeOutcome(<-outcomes)
+	}
+}
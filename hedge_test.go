@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendWithHedgeRecoversPanics(t *testing.T) {
+	panics := func(*ProviderRequest) (interface{}, error) { panic("conversion bug") }
+	slow := func(ctx context.Context, req *ProviderRequest) (*http.Response, error) {
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return fakeResponse(http.StatusOK, "data: {}\n\n"), nil
+	}
+	tests := []struct {
+		name       string
+		primary    *fakeProvider
+		hedge      *fakeProvider
+		wantWinner string
+		wantErr    bool
+	}{
+		{"primary panics before hedge is sent", &fakeProvider{convert: panics}, &fakeProvider{}, "", true},
+		{"hedge panics, primary wins", &fakeProvider{do: slow}, &fakeProvider{convert: panics}, "primary", false},
+		{"primary panics after hedge is sent", &fakeProvider{do: func(ctx context.Context, req *ProviderRequest) (*http.Response, error) {
+			time.Sleep(20 * time.Millisecond)
+			panic("transport bug")
+		}}, &fakeProvider{do: slow}, "hedge", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built := useTestUpstreams(t, map[string]Provider{"primary": tt.primary, "backup": tt.hedge}, "primary")
+			route := Route{
+				Upstream: built["primary"],
+				Model:    "m",
+				Hedge:    &RouteHedge{Target: "backup:m", Delay: 5 * time.Millisecond},
+			}
+			result, hedgeLog, err := sendWithHedge(context.Background(), route, MessageCreateParamsBase{Model: "m", Stream: true}, nil, http.Header{}, clientCredentials{})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "panicked") {
+					t.Fatalf("err = %v, want panic error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sendWithHedge: %v", err)
+			}
+			defer result.Response.Body.Close()
+			if hedgeLog == nil || hedgeLog.Winner != tt.wantWinner {
+				t.Fatalf("hedge log = %+v, want winner %s", hedgeLog, tt.wantWinner)
+			}
+		})
+	}
+}
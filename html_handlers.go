@@ -32,7 +32,7 @@ const indexHtml = `<!DOCTYPE html>
             font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, 'PingFang SC', 'Microsoft YaHei', sans-serif;
             line-height: 1.6;
             color: #333;
-            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%);
             min-height: 100vh;
             padding: 20px;
         }
@@ -89,7 +89,7 @@ const indexHtml = `<!DOCTYPE html>
             color: white;
             width: 28px;
             height: 28px;
-            border-radius: 50%;
+            border-radius: 50%%;
             display: flex;
             align-items: center;
             justify-content: center;
@@ -571,7 +571,7 @@ const privacyHtml = `<!DOCTYPE html>
         <p>我们不会出于营销目的出售、出租或与第三方共享您的数据。您的 API 请求会根据服务运行需要转发给上游提供商。</p>
 
         <h2>5. 数据安全</h2>
-        <p>我们实施合理的安全措施来保护我们处理的数据。但是，没有通过互联网传输的方法是 100% 安全的。</p>
+        <p>我们实施合理的安全措施来保护我们处理的数据。但是，没有通过互联网传输的方法是 100%% 安全的。</p>
 
         <h2>6. Cookie 和跟踪</h2>
         <p>对于核心 API 服务，我们不使用 Cookie 或跟踪技术。网站可能会使用基本分析来了解使用模式。</p>
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errStreamIdleTimeout 上游响应体长时间没有数据
+var errStreamIdleTimeout = errors.New("upstream stream idle timeout")
+
+// HTTPConfig 上游HTTP连接配置，所有时间单位为毫秒，0表示使用默认值
+type HTTPConfig struct {
+	DialTimeoutMs           int   `json:"dial_timeout_ms,omitempty"`            // 建立TCP连接超时，默认10000
+	KeepAliveMs             int   `json:"keep_alive_ms,omitempty"`              // TCP keep-alive间隔，默认30000
+	TLSHandshakeTimeoutMs   int   `json:"tls_handshake_timeout_ms,omitempty"`   // TLS握手超时，默认10000
+	ResponseHeaderTimeoutMs int   `json:"response_header_timeout_ms,omitempty"` // 等待响应头超时，默认600000
+	IdleConnTimeoutMs       int   `json:"idle_conn_timeout_ms,omitempty"`       // 空闲连接保留时间，默认90000
+	MaxIdleConns            int   `json:"max_idle_conns,omitempty"`             // 默认100
+	MaxIdleConnsPerHost     int   `json:"max_idle_conns_per_host,omitempty"`    // 默认32
+	MaxConnsPerHost         int   `json:"max_conns_per_host,omitempty"`         // 默认不限制
+	HTTP2                   *bool `json:"http2,omitempty"`                      // 是否尝试HTTP/2，默认true
+	RequestTimeoutMs        int   `json:"request_timeout_ms,omitempty"`         // 整个请求(包括读完流式响应)的超时，默认不限制
+	StreamIdleTimeoutMs     int   `json:"stream_idle_timeout_ms,omitempty"`     // 响应体两次数据之间的最长间隔，默认120000
+}
+
+// upstreamClient 上游共享的HTTP客户端
+type upstreamClient struct {
+	client            *http.Client
+	streamIdleTimeout time.Duration
+}
+
+// millis 将毫秒配置转换为时长，未配置时使用默认值
+func millis(value int, defaultValue time.Duration) time.Duration {
+	if value > 0 {
+		return time.Duration(value) * time.Millisecond
+	}
+	return defaultValue
+}
+
+// newUpstreamClient 根据配置创建上游HTTP客户端，每个上游一个，连接在请求之间复用
+func newUpstreamClient(config *HTTPConfig) *upstreamClient {
+	if config == nil {
+		config = &HTTPConfig{}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   millis(config.DialTimeoutMs, 10*time.Second),
+		KeepAlive: millis(config.KeepAliveMs, 30*time.Second),
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   millis(config.TLSHandshakeTimeoutMs, 10*time.Second),
+		ResponseHeaderTimeout: millis(config.ResponseHeaderTimeoutMs, 600*time.Second),
+		IdleConnTimeout:       millis(config.IdleConnTimeoutMs, 90*time.Second),
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   32,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+	}
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.HTTP2 != nil && !*config.HTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return &upstreamClient{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   millis(config.RequestTimeoutMs, 0),
+		},
+		streamIdleTimeout: millis(config.StreamIdleTimeoutMs, 120*time.Second),
+	}
+}
+
+// CloseIdleConnections 关闭连接池中的空闲连接，进行中的请求不受影响
+func (c *upstreamClient) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+// Do 发送请求，响应体在长时间没有数据时自动关闭
+func (c *upstreamClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.streamIdleTimeout > 0 {
+		resp.Body = newIdleTimeoutBody(resp.Body, c.streamIdleTimeout)
+	}
+	return resp, nil
+}
+
+// idleTimeoutBody 读取间隔超过timeout时关闭底层响应体，避免上游挂起时goroutine一直阻塞
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+
+	mu       sync.Mutex
+	timedOut bool
+}
+
+// newIdleTimeoutBody 包装响应体
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		b.timedOut = true
+		b.mu.Unlock()
+		b.body.Close()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		timedOut := b.timedOut
+		b.mu.Unlock()
+		if timedOut {
+			return n, errStreamIdleTimeout
+		}
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
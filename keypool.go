@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// errNoUpstreamKey 密钥池中所有密钥都处于隔离期
+var errNoUpstreamKey = errors.New("no upstream API key available")
+
+// defaultKeyCooldown 密钥被隔离后重新尝试前的默认等待时间
+const defaultKeyCooldown = 5 * time.Minute
+
+// KeyPoolConfig 上游密钥池配置
+type KeyPoolConfig struct {
+	Strategy        string          `json:"strategy,omitempty"`         // round_robin(默认)、weighted 或 least_used
+	CooldownSeconds int             `json:"cooldown_seconds,omitempty"` // 返回401/402/429后的隔离时长
+	Keys            []PoolKeyConfig `json:"keys"`
+}
+
+// PoolKeyConfig 密钥池中的单个密钥
+type PoolKeyConfig struct {
+	Name   string `json:"name,omitempty"`
+	Key    string `json:"key"` // 支持 ${ENV} 引用
+	Weight int    `json:"weight,omitempty"`
+}
+
+// pooledKey 密钥及其运行状态
+type pooledKey struct {
+	name   string
+	key    string
+	weight int
+
+	inFlight         int
+	uses             int64
+	currentWeight    int // 平滑加权轮询的当前权重
+	quarantinedUntil time.Time
+	probing          bool // 隔离期结束后正在用一个请求重新测试
+	lastStatus       int
+}
+
+// keyPool 上游密钥池
+type keyPool struct {
+	mu       sync.Mutex
+	strategy string
+	cooldown time.Duration
+	keys     []*pooledKey
+	next     int
+}
+
+// newKeyPool 根据配置创建密钥池
+func newKeyPool(config *KeyPoolConfig) (*keyPool, error) {
+	pool := &keyPool{strategy: config.Strategy, cooldown: defaultKeyCooldown}
+	switch pool.strategy {
+	case "":
+		pool.strategy = "round_robin"
+	case "round_robin", "weighted", "least_used":
+	default:
+		return nil, fmt.Errorf("unknown key pool strategy %q", config.Strategy)
+	}
+	if config.CooldownSeconds > 0 {
+		pool.cooldown = time.Duration(config.CooldownSeconds) * time.Second
+	}
+
+	for i, keyConfig := range config.Keys {
+		key := os.ExpandEnv(keyConfig.Key)
+		if key == "" {
+			return nil, fmt.Errorf("key pool entry %d is empty", i)
+		}
+		name := keyConfig.Name
+		if name == "" {
+			name = fmt.Sprintf("key-%d", i+1)
+		}
+		weight := keyConfig.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		pool.keys = append(pool.keys, &pooledKey{name: name, key: key, weight: weight})
+	}
+	if len(pool.keys) == 0 {
+		return nil, errors.New("key pool has no keys")
+	}
+	return pool, nil
+}
+
+// available 判断密钥当前是否可用；隔离期已过的密钥只允许一个请求重新测试
+func (k *pooledKey) available(now time.Time) bool {
+	if k.quarantinedUntil.IsZero() {
+		return true
+	}
+	return !k.probing && !now.Before(k.quarantinedUntil)
+}
+
+// Acquire 按策略选择一个可用密钥
+func (p *keyPool) Acquire() (*pooledKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.acquireLocked()
+}
+
+// AcquirePreferred 优先使用指定名称的密钥(如会话亲和绑定的密钥)，不可用时按策略选择
+func (p *keyPool) AcquirePreferred(name string) (*pooledKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if name != "" {
+		now := time.Now()
+		for _, key := range p.keys {
+			if key.name == name && key.available(now) {
+				p.take(key)
+				return key, nil
+			}
+		}
+	}
+	return p.acquireLocked()
+}
+
+// AcquireHealthy 选择并发最少的未隔离密钥，不占用隔离密钥的探测名额
+// 用于影子请求：其结果不通过Report反馈，影子流量不会隔离主流量使用的密钥
+func (p *keyPool) AcquireHealthy() (*pooledKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var selected *pooledKey
+	for _, key := range p.keys {
+		if key.quarantinedUntil.IsZero() && (selected == nil || key.inFlight < selected.inFlight) {
+			selected = key
+		}
+	}
+	if selected == nil {
+		return nil, errNoUpstreamKey
+	}
+	selected.inFlight++
+	return selected, nil
+}
+
+// acquireLocked 按策略选择密钥，调用方需持有锁
+func (p *keyPool) acquireLocked() (*pooledKey, error) {
+	now := time.Now()
+	var candidates []*pooledKey
+	for _, key := range p.keys {
+		if key.available(now) {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errNoUpstreamKey
+	}
+
+	var selected *pooledKey
+	switch p.strategy {
+	case "weighted":
+		// 平滑加权轮询
+		total := 0
+		for _, key := range candidates {
+			key.currentWeight += key.weight
+			total += key.weight
+			if selected == nil || key.currentWeight > selected.currentWeight {
+				selected = key
+			}
+		}
+		selected.currentWeight -= total
+	case "least_used":
+		for _, key := range candidates {
+			if selected == nil || key.inFlight < selected.inFlight ||
+				(key.inFlight == selected.inFlight && key.uses < selected.uses) {
+				selected = key
+			}
+		}
+	default:
+		selected = candidates[p.next%len(candidates)]
+		p.next++
+	}
+
+	p.take(selected)
+	return selected, nil
+}
+
+// take 标记密钥被占用，调用方需持有锁
+func (p *keyPool) take(key *pooledKey) {
+	if !key.quarantinedUntil.IsZero() {
+		key.probing = true
+	}
+	key.inFlight++
+	key.uses++
+}
+
+// Report 根据上游结果更新密钥状态，返回密钥是否被隔离
+// 401/402/429 隔离密钥；429 带有更长的 Retry-After 时按其延长隔离时间；
+// 其他状态码(包括5xx)说明密钥本身可用，解除隔离，避免密钥一直停留在单请求探测状态
+func (p *keyPool) Report(key *pooledKey, resp *http.Response, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key.probing = false
+	if err != nil {
+		return false
+	}
+	key.lastStatus = resp.StatusCode
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusTooManyRequests:
+		cooldown := p.cooldown
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if wait, ok := parseRetryAfter(resp.Header, time.Now()); ok && wait > cooldown {
+				cooldown = wait
+			}
+		}
+		key.quarantinedUntil = time.Now().Add(cooldown)
+		return true
+	}
+	key.quarantinedUntil = time.Time{}
+	return false
+}
+
+// Release 请求结束(包括流式响应读完)时释放密钥
+func (p *keyPool) Release(key *pooledKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key.inFlight--
+}
+
+// HasAvailable 判断是否还有可用密钥
+func (p *keyPool) HasAvailable() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range p.keys {
+		if key.available(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Size 返回密钥数量
+func (p *keyPool) Size() int {
+	return len(p.keys)
+}
+
+// releaseOnClose 在响应体关闭时释放密钥，使least_used统计包括仍在进行的流式响应
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
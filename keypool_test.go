@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientCredentialsForUpstream(t *testing.T) {
+	pool, err := newKeyPool(&KeyPoolConfig{Keys: []PoolKeyConfig{{Key: "pooled"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := &Upstream{Name: "open"}
+	withKey := &Upstream{Name: "with-key", Config: UpstreamConfig{APIKey: "server"}}
+	withPool := &Upstream{Name: "with-pool", Keys: pool}
+	withAAD := &Upstream{Name: "with-aad", Config: UpstreamConfig{AADTokenFile: "token"}}
+	withHeader := &Upstream{Name: "with-header", Config: UpstreamConfig{Headers: map[string]string{"Authorization": "Bearer server"}}}
+	local := &Upstream{Name: "local", Provider: &ollamaProvider{}}
+	virtualKey := &VirtualKey{ID: "vk_1", Credentials: map[string]string{"with-key": "personal", "open": "own"}}
+	bareKey := &VirtualKey{ID: "vk_2"}
+
+	tests := []struct {
+		name           string
+		client         clientCredentials
+		upstream       *Upstream
+		allowAnonymous bool
+		wantKey        string
+		wantPool       bool
+		wantErr        error
+	}{
+		{"client key forwarded to open upstream", clientCredentials{APIKey: "client"}, open, false, "client", true, nil},
+		{"client key cannot use server api_key", clientCredentials{APIKey: "client"}, withKey, false, "", false, errVirtualKeyRequired},
+		{"client key cannot use key pool", clientCredentials{APIKey: "client"}, withPool, false, "", false, errVirtualKeyRequired},
+		{"allow_anonymous uses server api_key", clientCredentials{APIKey: "client"}, withKey, true, "server", true, nil},
+		{"allow_anonymous uses key pool", clientCredentials{APIKey: "client"}, withPool, true, "client", true, nil},
+		{"virtual key credential", clientCredentials{VirtualKey: virtualKey}, withKey, false, "personal", false, nil},
+		{"virtual key uses key pool", clientCredentials{VirtualKey: virtualKey}, withPool, false, "", true, nil},
+		{"client key cannot use AAD token", clientCredentials{APIKey: "client"}, withAAD, false, "", false, errVirtualKeyRequired},
+		{"client key cannot use credential header", clientCredentials{APIKey: "client"}, withHeader, false, "", false, errVirtualKeyRequired},
+		{"client key forwarded to keyless upstream", clientCredentials{APIKey: "client"}, local, false, "client", true, nil},
+		{"virtual key credential for open upstream", clientCredentials{VirtualKey: virtualKey}, open, false, "own", false, nil},
+		{"virtual key never forwarded", clientCredentials{APIKey: "sk-yr-secret", VirtualKey: bareKey}, withKey, false, "server", true, nil},
+		{"virtual key without credential for open upstream", clientCredentials{APIKey: "sk-yr-secret", VirtualKey: bareKey}, open, false, "", false, errNoUpstreamCredential},
+		{"virtual key uses AAD token", clientCredentials{VirtualKey: bareKey}, withAAD, false, "", true, nil},
+		{"virtual key uses keyless upstream", clientCredentials{VirtualKey: bareKey}, local, false, "", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := env.AllowAnonymous
+			env.AllowAnonymous = tt.allowAnonymous
+			t.Cleanup(func() { env.AllowAnonymous = previous })
+
+			key, usePool, err := tt.client.forUpstream(tt.upstream)
+			if !errors.Is(err, tt.wantErr) || key != tt.wantKey || usePool != tt.wantPool {
+				t.Fatalf("forUpstream = %q, %v, %v, want %q, %v, %v", key, usePool, err, tt.wantKey, tt.wantPool, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendWithFallbackSkipsServerCredentialsForAnonymous(t *testing.T) {
+	var used []string
+	record := func(name string) *fakeProvider {
+		return &fakeProvider{do: func(ctx context.Context, req *ProviderRequest) (*http.Response, error) {
+			used = append(used, name+":"+req.APIKey)
+			return fakeResponse(http.StatusOK, "{}"), nil
+		}}
+	}
+	built := useTestUpstreams(t, map[string]Provider{"paid": record("paid"), "own": record("own")}, "paid")
+	built["paid"].Config.APIKey = "server"
+	route := Route{Upstream: built["paid"], Model: "m", Fallbacks: []RouteTarget{{Upstream: built["own"], Model: "m"}}}
+
+	result, err := sendWithFallback(context.Background(), route, MessageCreateParamsBase{}, nil, nil, clientCredentials{APIKey: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Target.Upstream.Name != "own" || len(used) != 1 || used[0] != "own:client" {
+		t.Fatalf("target = %s, requests = %v", result.Target.Upstream.Name, used)
+	}
+
+	used = nil
+	_, err = sendWithFallback(context.Background(), Route{Upstream: built["paid"], Model: "m"}, MessageCreateParamsBase{}, nil, nil, clientCredentials{APIKey: "client"})
+	if !errors.Is(err, errVirtualKeyRequired) || len(used) != 0 {
+		t.Fatalf("err = %v, requests = %v", err, used)
+	}
+}
+
+func TestKeyPoolStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   KeyPoolConfig
+		acquires int
+		want     []string
+	}{
+		{"round robin", KeyPoolConfig{Keys: []PoolKeyConfig{{Key: "a"}, {Key: "b"}, {Key: "c"}}}, 4, []string{"key-1", "key-2", "key-3", "key-1"}},
+		{"smooth weighted", KeyPoolConfig{Strategy: "weighted", Keys: []PoolKeyConfig{{Name: "big", Key: "a", Weight: 2}, {Name: "small", Key: "b", Weight: 1}}}, 6,
+			[]string{"big", "small", "big", "big", "small", "big"}},
+		{"least used", KeyPoolConfig{Strategy: "least_used", Keys: []PoolKeyConfig{{Name: "x", Key: "a"}, {Name: "y", Key: "b"}}}, 3, []string{"x", "y", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := newKeyPool(&tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for i := 0; i < tt.acquires; i++ {
+				key, err := pool.Acquire()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, key.name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyPoolQuarantine(t *testing.T) {
+	pool, err := newKeyPool(&KeyPoolConfig{CooldownSeconds: 60, Keys: []PoolKeyConfig{{Name: "a", Key: "1"}, {Name: "b", Key: "2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		status      int
+		quarantined bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusUnauthorized, true},
+		{http.StatusPaymentRequired, true},
+		{http.StatusTooManyRequests, true},
+	}
+	for _, tt := range tests {
+		key := pool.keys[0]
+		key.quarantinedUntil = time.Time{}
+		if got := pool.Report(key, fakeResponse(tt.status, ""), nil); got != tt.quarantined {
+			t.Fatalf("status %d: quarantined = %v, want %v", tt.status, got, tt.quarantined)
+		}
+	}
+
+	// a被隔离后只选择b；b也被隔离时没有可用密钥
+	for i := 0; i < 3; i++ {
+		key, err := pool.Acquire()
+		if err != nil || key.name != "b" {
+			t.Fatalf("acquired %v, %v, want b", key, err)
+		}
+		pool.Release(key)
+	}
+	resp := fakeResponse(http.StatusTooManyRequests, "")
+	resp.Header.Set("Retry-After", "600")
+	pool.Report(pool.keys[1], resp, nil)
+	if until := time.Until(pool.keys[1].quarantinedUntil); until < 9*time.Minute {
+		t.Fatalf("Retry-After should extend the cooldown, got %v", until)
+	}
+	if _, err := pool.Acquire(); err != errNoUpstreamKey {
+		t.Fatalf("err = %v, want errNoUpstreamKey", err)
+	}
+
+	// 隔离期结束后只放行一个探测请求，成功后恢复
+	pool.keys[0].quarantinedUntil = time.Now().Add(-time.Second)
+	probe, err := pool.Acquire()
+	if err != nil || probe.name != "a" {
+		t.Fatalf("probe = %v, %v", probe, err)
+	}
+	if _, err := pool.Acquire(); err != errNoUpstreamKey {
+		t.Fatalf("second request during probe: err = %v", err)
+	}
+	pool.Report(probe, fakeResponse(http.StatusOK, ""), nil)
+	pool.Release(probe)
+	if key, err := pool.Acquire(); err != nil || key.name != "a" {
+		t.Fatalf("after successful probe: %v, %v", key, err)
+	}
+}
+
+func TestKeyPoolProbeOutcome(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          int
+		err             error
+		wantQuarantined bool
+		wantConcurrent  bool // 探测结束后同一密钥能否同时处理多个请求
+	}{
+		{"success clears quarantine", http.StatusOK, nil, false, true},
+		{"server error clears quarantine", http.StatusInternalServerError, nil, false, true},
+		{"bad request clears quarantine", http.StatusBadRequest, nil, false, true},
+		{"rate limited stays quarantined", http.StatusTooManyRequests, nil, true, false},
+		{"unauthorized stays quarantined", http.StatusUnauthorized, nil, true, false},
+		{"connection error allows another probe", 0, errors.New("connection reset"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := newKeyPool(&KeyPoolConfig{Keys: []PoolKeyConfig{{Name: "a", Key: "1"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool.keys[0].quarantinedUntil = time.Now().Add(-time.Second)
+			probe, err := pool.Acquire()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var resp *http.Response
+			if tt.err == nil {
+				resp = fakeResponse(tt.status, "")
+			}
+			if got := pool.Report(probe, resp, tt.err); got != tt.wantQuarantined {
+				t.Fatalf("Report = %v, want %v", got, tt.wantQuarantined)
+			}
+			pool.Release(probe)
+
+			concurrent := 0
+			for i := 0; i < 2; i++ {
+				if _, err := pool.Acquire(); err == nil {
+					concurrent++
+				}
+			}
+			if (concurrent == 2) != tt.wantConcurrent {
+				t.Fatalf("acquired %d concurrent requests after the probe, want concurrent = %v", concurrent, tt.wantConcurrent)
+			}
+		})
+	}
+}
@@ -20,14 +20,24 @@ type DataLogger struct {
 
 // SessionLog 会话日志，包含一次请求的所有数据
 type SessionLog struct {
-	RequestID         string      `json:"request_id"`
-	Timestamp         string      `json:"timestamp"`
-	AnthropicRequest  interface{} `json:"anthropic_request,omitempty"`
-	OpenAIRequest     interface{} `json:"openai_request,omitempty"`
-	OpenAIResponse    interface{} `json:"openai_response,omitempty"`
-	AnthropicResponse interface{} `json:"anthropic_response,omitempty"`
-	StreamData        string      `json:"stream_data,omitempty"`
-	IsStreaming       bool        `json:"is_streaming"`
+	RequestID         string            `json:"request_id"`
+	Timestamp         string            `json:"timestamp"`
+	AnthropicRequest  interface{}       `json:"anthropic_request,omitempty"`
+	OpenAIRequest     interface{}       `json:"openai_request,omitempty"`
+	OpenAIResponse    interface{}       `json:"openai_response,omitempty"`
+	AnthropicResponse interface{}       `json:"anthropic_response,omitempty"`
+	StreamData        string            `json:"stream_data,omitempty"`
+	IsStreaming       bool              `json:"is_streaming"`
+	Upstream          string            `json:"upstream,omitempty"`
+	UpstreamModel     string            `json:"upstream_model,omitempty"`
+	UpstreamAttempts  []UpstreamAttempt `json:"upstream_attempts,omitempty"`
+	Hedge             *HedgeLog         `json:"hedge,omitempty"`
+	Variant           string            `json:"variant,omitempty"`     // A/B分流变体
+	Mirror            *MirrorLog        `json:"mirror,omitempty"`      // 影子请求，与主请求的响应并列记录
+	VirtualKey        string            `json:"virtual_key,omitempty"` // 虚拟密钥ID
+
+	holds int  // 会话结束后仍在写入的影子请求数
+	ended bool // 主请求已经结束
 }
 
 // NewDataLogger 创建新的数据记录器
@@ -113,6 +123,80 @@ func (l *DataLogger) LogAnthropicResponse(requestID string, data interface{}) {
 	}
 }
 
+// LogUpstream 记录实际响应的上游和全部尝试
+func (l *DataLogger) LogUpstream(requestID string, upstream *Upstream, model string, attempts []UpstreamAttempt) {
+	if !l.enabled {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if session, exists := l.sessions[requestID]; exists {
+		if upstream != nil {
+			session.Upstream = upstream.Name
+			session.UpstreamModel = model
+		}
+		session.UpstreamAttempts = attempts
+	}
+}
+
+// LogHedge 记录对冲请求结果
+func (l *DataLogger) LogHedge(requestID string, hedge *HedgeLog) {
+	if !l.enabled || hedge == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if session, exists := l.sessions[requestID]; exists {
+		session.Hedge = hedge
+	}
+}
+
+// LogVariant 记录A/B分流选中的变体
+func (l *DataLogger) LogVariant(requestID string, variant string) {
+	if !l.enabled {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if session, exists := l.sessions[requestID]; exists {
+		session.Variant = variant
+	}
+}
+
+// LogVirtualKey 记录请求使用的虚拟密钥ID
+func (l *DataLogger) LogVirtualKey(requestID string, id string) {
+	if !l.enabled {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if session, exists := l.sessions[requestID]; exists {
+		session.VirtualKey = id
+	}
+}
+
+// LogMirror 记录影子请求结果
+func (l *DataLogger) LogMirror(requestID string, mirror *MirrorLog) {
+	if !l.enabled {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if session, exists := l.sessions[requestID]; exists {
+		session.Mirror = mirror
+	}
+}
+
 // LogStreamData 记录流式响应的完整数据
 func (l *DataLogger) LogStreamData(requestID string, data string) {
 	if !l.enabled || !l.config.LogAnthropicResponse {
@@ -128,6 +212,42 @@ func (l *DataLogger) LogStreamData(requestID string, data string) {
 	}
 }
 
+// HoldSession 在会话结束后继续保留会话，直到对应的ReleaseSession被调用(如影子请求仍在进行)
+func (l *DataLogger) HoldSession(requestID string) bool {
+	if !l.enabled {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	session, exists := l.sessions[requestID]
+	if !exists {
+		return false
+	}
+	session.holds++
+	return true
+}
+
+// ReleaseSession 释放HoldSession；会话已经结束且没有其他保留时保存日志
+func (l *DataLogger) ReleaseSession(requestID string) error {
+	l.mu.Lock()
+	session, exists := l.sessions[requestID]
+	if !exists {
+		l.mu.Unlock()
+		return nil
+	}
+	session.holds--
+	if !session.ended || session.holds > 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	delete(l.sessions, requestID)
+	l.mu.Unlock()
+
+	return l.writeSession(session)
+}
+
 // EndSession 结束会话并保存日志
 func (l *DataLogger) EndSession(requestID string) error {
 	if !l.enabled {
@@ -140,9 +260,20 @@ func (l *DataLogger) EndSession(requestID string) error {
 		l.mu.Unlock()
 		return nil
 	}
+	if session.holds > 0 {
+		// 等待最后一个ReleaseSession保存
+		session.ended = true
+		l.mu.Unlock()
+		return nil
+	}
 	delete(l.sessions, requestID)
 	l.mu.Unlock()
 
+	return l.writeSession(session)
+}
+
+// writeSession 将会话写入日志文件
+func (l *DataLogger) writeSession(session *SessionLog) error {
 	// 创建日志目录
 	if err := os.MkdirAll(l.directory, 0755); err != nil {
 		return fmt.Errorf("failed to create log directory: %w", err)
@@ -150,7 +281,7 @@ func (l *DataLogger) EndSession(requestID string) error {
 
 	// 生成文件名：时间戳_请求ID.json
 	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s.json", timestamp, requestID)
+	filename := fmt.Sprintf("%s_%s.json", timestamp, session.RequestID)
 	fullPath := filepath.Join(l.directory, filename)
 
 	// 格式化JSON
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,7 +26,25 @@ type LoggingConfig struct {
 type Env struct {
 	OpenRouterBaseUrl string            `json:"openrouter_base_url"`
 	ModelMappings     map[string]string `json:"model_mappings"`
+	ModelRules        []ModelRule       `json:"model_rules"`
+	FileInputModels   []string          `json:"file_input_models"`
+	Upstream          UpstreamConfig    `json:"upstream"`
+	Upstreams         map[string]UpstreamConfig `json:"upstreams"`
+	DefaultUpstream   string            `json:"default_upstream"`
+	Passthrough       PassthroughConfig `json:"passthrough"`
 	DataLogging       LoggingConfig     `json:"data_logging"`
+	AdminToken        string            `json:"admin_token"`
+	AdminTokens       map[string]string `json:"admin_tokens"`
+	AdminAuditLog     string            `json:"admin_audit_log"`
+	AdminAllowLocalhost bool            `json:"admin_allow_localhost"`
+	Mirror            MirrorSettings    `json:"mirror"`
+	CacheAffinity     CacheAffinityConfig `json:"cache_affinity"`
+	VirtualKeys       VirtualKeysConfig `json:"virtual_keys"`
+	AllowAnonymous    bool              `json:"allow_anonymous"`
+	RateLimits        RateLimitSettings `json:"rate_limits"`
+	TrustedProxies    []string          `json:"trusted_proxies"`
+	Budgets           BudgetSettings    `json:"budgets"`
+	TLS               TLSConfig         `json:"tls"`
 }
 
 var env Env
@@ -39,10 +62,12 @@ func init() {
 	dataLogger = NewDataLogger(env.DataLogging)
 }
 
+// configPath 配置文件路径，管理接口修改配置后写回该文件
+const configPath = "config.json"
+
 func loadConfig() {
-	configFile := "config.json"
-	if _, err := os.Stat(configFile); err == nil {
-		file, err := os.Open(configFile)
+	if _, err := os.Stat(configPath); err == nil {
+		file, err := os.Open(configPath)
 		if err != nil {
 			log.Printf("Failed to open config file: %v", err)
 			return
@@ -52,7 +77,25 @@ func loadConfig() {
 		var config struct {
 			OpenRouterBaseUrl string            `json:"openrouter_base_url"`
 			ModelMappings     map[string]string `json:"model_mappings"`
+			ModelRules        []ModelRule       `json:"model_rules"`
+			FileInputModels   []string          `json:"file_input_models"`
+			Upstream          UpstreamConfig    `json:"upstream"`
+			Upstreams         map[string]UpstreamConfig `json:"upstreams"`
+			DefaultUpstream   string            `json:"default_upstream"`
+			Passthrough       PassthroughConfig `json:"passthrough"`
 			DataLogging       LoggingConfig     `json:"data_logging"`
+			AdminToken        string            `json:"admin_token"`
+			AdminTokens       map[string]string `json:"admin_tokens"`
+			AdminAuditLog     string            `json:"admin_audit_log"`
+			AdminAllowLocalhost bool            `json:"admin_allow_localhost"`
+			Mirror            MirrorSettings    `json:"mirror"`
+			CacheAffinity     CacheAffinityConfig `json:"cache_affinity"`
+			VirtualKeys       VirtualKeysConfig `json:"virtual_keys"`
+			AllowAnonymous    bool              `json:"allow_anonymous"`
+			RateLimits        RateLimitSettings `json:"rate_limits"`
+			TrustedProxies    []string          `json:"trusted_proxies"`
+			Budgets           BudgetSettings    `json:"budgets"`
+			TLS               TLSConfig         `json:"tls"`
 		}
 		
 		decoder := json.NewDecoder(file)
@@ -65,8 +108,26 @@ func loadConfig() {
 			env.OpenRouterBaseUrl = config.OpenRouterBaseUrl
 		}
 		env.ModelMappings = config.ModelMappings
+		env.ModelRules = config.ModelRules
+		env.FileInputModels = config.FileInputModels
+		env.Upstream = config.Upstream
+		env.Upstreams = config.Upstreams
+		env.DefaultUpstream = config.DefaultUpstream
+		env.Passthrough = config.Passthrough
 		env.DataLogging = config.DataLogging
-		log.Printf("Loaded configuration with %d model mappings", len(env.ModelMappings))
+		env.AdminToken = config.AdminToken
+		env.AdminTokens = config.AdminTokens
+		env.AdminAuditLog = config.AdminAuditLog
+		env.AdminAllowLocalhost = config.AdminAllowLocalhost
+		env.Mirror = config.Mirror
+		env.CacheAffinity = config.CacheAffinity
+		env.VirtualKeys = config.VirtualKeys
+		env.AllowAnonymous = config.AllowAnonymous
+		env.RateLimits = config.RateLimits
+		env.TrustedProxies = config.TrustedProxies
+		env.Budgets = config.Budgets
+		env.TLS = config.TLS
+		log.Printf("Loaded configuration with %d model rules and %d model mappings", len(env.ModelRules), len(env.ModelMappings))
 		log.Printf("Data logging enabled: %v", env.DataLogging.Enabled)
 	} else {
 		// Default mappings if config file doesn't exist
@@ -75,6 +136,7 @@ func loadConfig() {
 			"sonnet": "anthropic/claude-sonnet-4",
 			"opus":   "anthropic/claude-opus-4",
 		}
+		env.FileInputModels = []string{"anthropic/claude", "google/gemini"}
 		log.Printf("Using default model mappings")
 	}
 }
@@ -87,7 +149,22 @@ func getEnv(key, defaultValue string) string {
 }
 
 func main() {
+	if err := initModelRules(); err != nil {
+		log.Fatalf("Invalid model rules: %v", err)
+	}
+	initUpstreams()
+	if err := initVirtualKeys(); err != nil {
+		log.Fatalf("Failed to load virtual keys: %v", err)
+	}
+	if err := initBudgets(); err != nil {
+		log.Fatalf("Invalid budgets: %v", err)
+	}
+
 	r := gin.Default()
+	// 默认不信任任何代理，客户端IP取自连接地址，避免伪造 X-Forwarded-For 绕过按IP限流或篡改审计日志
+	if err := r.SetTrustedProxies(env.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted_proxies: %v", err)
+	}
 
 	// 静态页面路由
 	r.GET("/", handleIndex)
@@ -97,9 +174,43 @@ func main() {
 
 	// API路由
 	r.POST("/v1/messages", handleMessages)
+	registerAdminRoutes(r)
 
 	// 启动服务器
 	port := getEnv("PORT", "8080")
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	if env.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(env.TLS)
+		if err != nil {
+			log.Fatalf("Invalid TLS config: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+	}
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			log.Printf("Server starting on port %s with TLS", port)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Server starting on port %s", port)
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// 收到退出信号后等待进行中的请求结束，再保存尚未写回的用量
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Printf("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down gracefully: %v", err)
+	}
+	if err := spend.Close(); err != nil {
+		log.Printf("Failed to save usage: %v", err)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// MirrorConfig 规则的影子请求配置：把请求副本异步发送到Target，结果只写入日志
+type MirrorConfig struct {
+	Target     string  `json:"target"`                // "upstream:model"
+	SampleRate float64 `json:"sample_rate,omitempty"` // 镜像的请求比例，0-1，默认1
+}
+
+// MirrorSettings 影子请求的全局限制
+type MirrorSettings struct {
+	MaxConcurrency int `json:"max_concurrency,omitempty"` // 同时进行的影子请求上限，超出时直接丢弃，默认4
+	TimeoutMs      int `json:"timeout_ms,omitempty"`      // 单个影子请求的超时，默认300000
+}
+
+// RouteMirror 解析后的影子目标
+type RouteMirror struct {
+	Target     string
+	SampleRate float64
+}
+
+// MirrorLog 影子请求记录
+type MirrorLog struct {
+	Upstream          string      `json:"upstream"`
+	Model             string      `json:"model"`
+	StatusCode        int         `json:"status_code,omitempty"`
+	Error             string      `json:"error,omitempty"`
+	DurationMs        int64       `json:"duration_ms"`
+	UpstreamRequest   interface{} `json:"upstream_request,omitempty"`
+	UpstreamResponse  interface{} `json:"upstream_response,omitempty"`
+	AnthropicResponse interface{} `json:"anthropic_response,omitempty"`
+	StreamData        string      `json:"stream_data,omitempty"`
+}
+
+var (
+	mirrorSlots     chan struct{}
+	mirrorSlotsOnce sync.Once
+)
+
+// mirrorSemaphore 返回限制影子请求并发的信号量
+func mirrorSemaphore() chan struct{} {
+	mirrorSlotsOnce.Do(func() {
+		size := env.Mirror.MaxConcurrency
+		if size <= 0 {
+			size = 4
+		}
+		mirrorSlots = make(chan struct{}, size)
+	})
+	return mirrorSlots
+}
+
+// startMirror 按路由配置异步发送影子请求，不阻塞主请求；并发已满时直接丢弃
+// 影子结果只写入数据流记录，未开启记录时不发送，避免白白消耗上游费用
+func startMirror(requestID string, route Route, anthropicRequest MessageCreateParamsBase, body []byte, header http.Header, client clientCredentials) {
+	if route.Mirror == nil || !dataLogger.enabled {
+		return
+	}
+	if route.Mirror.SampleRate < 1 && rand.Float64() >= route.Mirror.SampleRate {
+		return
+	}
+
+	// 主请求结束时影子请求可能仍在进行，保留会话直到影子结果写入
+	if !dataLogger.HoldSession(requestID) {
+		return
+	}
+	slots := mirrorSemaphore()
+	select {
+	case slots <- struct{}{}:
+	default:
+		dataLogger.ReleaseSession(requestID)
+		log.Printf("Mirror skipped for %s: concurrency limit reached", requestID)
+		return
+	}
+
+	header = header.Clone()
+	go func() {
+		var mirrorLog *MirrorLog
+		// 影子请求的panic不能影响主流量：记录后照常释放并发槽位和会话
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("Mirror request for %s panicked: %v\n%s", requestID, p, debug.Stack())
+				mirrorLog = &MirrorLog{Error: fmt.Sprintf("mirror request panicked: %v", p)}
+			}
+			dataLogger.LogMirror(requestID, mirrorLog)
+			dataLogger.ReleaseSession(requestID)
+			<-slots
+		}()
+		mirrorLog = runMirror(route.Mirror, anthropicRequest, body, header, client)
+	}()
+}
+
+// runMirror 发送影子请求并收集转换后的响应
+func runMirror(mirror *RouteMirror, anthropicRequest MessageCreateParamsBase, body []byte, header http.Header, client clientCredentials) *MirrorLog {
+	upstream, model := splitUpstreamTarget(mirror.Target)
+	provider := upstream.Provider
+	mirrorLog := &MirrorLog{Upstream: upstream.Name, Model: model}
+
+	timeout := 300 * time.Second
+	if env.Mirror.TimeoutMs > 0 {
+		timeout = time.Duration(env.Mirror.TimeoutMs) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if anthropicRequest.Stream && !provider.Capabilities(model).Streaming {
+		anthropicRequest.Stream = false
+	}
+	apiKey, usePool, err := client.forUpstream(upstream)
+	if err != nil {
+		mirrorLog.Error = err.Error()
+		return mirrorLog
+	}
+	providerRequest := &ProviderRequest{
+		Anthropic: anthropicRequest,
+		RawBody:   body,
+		Header:    header,
+		Model:     model,
+		APIKey:    apiKey,
+	}
+
+	var key *pooledKey
+	if usePool && upstream.Keys != nil {
+		var err error
+		key, err = upstream.Keys.AcquireHealthy()
+		if err != nil {
+			mirrorLog.Error = err.Error()
+			return mirrorLog
+		}
+		defer upstream.Keys.Release(key)
+		providerRequest.APIKey = key.key
+	}
+
+	upstreamRequest, err := provider.ConvertRequest(providerRequest)
+	if err != nil {
+		mirrorLog.Error = err.Error()
+		return mirrorLog
+	}
+	if dataLogger.config.LogOpenAIRequest {
+		mirrorLog.UpstreamRequest = upstreamRequest
+	}
+
+	start := time.Now()
+	// 影子请求的结果不反馈给密钥池，也不计入熔断器，与主流量共用的密钥和上游状态只由主流量决定
+	resp, err := provider.Do(ctx, providerRequest, upstreamRequest)
+	if err != nil {
+		mirrorLog.Error = err.Error()
+		mirrorLog.DurationMs = time.Since(start).Milliseconds()
+		return mirrorLog
+	}
+	defer resp.Body.Close()
+	mirrorLog.StatusCode = resp.StatusCode
+
+	if resp.StatusCode != http.StatusOK {
+		errorBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		mirrorLog.Error = string(errorBody)
+	} else if anthropicRequest.Stream {
+		anthropicStream := provider.ConvertStream(resp, providerRequest)
+		streamData, _ := io.ReadAll(anthropicStream)
+		anthropicStream.Close()
+		mirrorLog.StreamData = string(streamData)
+	} else {
+		upstreamResponse, anthropicResponse, err := provider.ConvertResponse(resp, providerRequest)
+		if err != nil {
+			mirrorLog.Error = err.Error()
+		}
+		if dataLogger.config.LogOpenAIResponse {
+			mirrorLog.UpstreamResponse = upstreamResponse
+		}
+		mirrorLog.AnthropicResponse = anthropicResponse
+	}
+	mirrorLog.DurationMs = time.Since(start).Milliseconds()
+	return mirrorLog
+}
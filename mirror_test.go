@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartMirrorRecoversPanics(t *testing.T) {
+	tests := []struct {
+		name      string
+		provider  *fakeProvider
+		wantError string
+	}{
+		{"success", &fakeProvider{}, ""},
+		{"convert panics", &fakeProvider{convert: func(*ProviderRequest) (interface{}, error) { panic("conversion bug") }}, "mirror request panicked: conversion bug"},
+		{"do panics", &fakeProvider{do: func(context.Context, *ProviderRequest) (*http.Response, error) { panic("transport bug") }}, "mirror request panicked: transport bug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built := useTestUpstreams(t, map[string]Provider{"primary": &fakeProvider{}, "shadow": tt.provider}, "primary")
+			dir := t.TempDir()
+			savedLogger := dataLogger
+			dataLogger = NewDataLogger(LoggingConfig{Enabled: true, Directory: dir})
+			t.Cleanup(func() { dataLogger = savedLogger })
+
+			dataLogger.StartSession("req-1")
+			route := Route{Upstream: built["primary"], Model: "m", Mirror: &RouteMirror{Target: "shadow:m", SampleRate: 1}}
+			startMirror("req-1", route, MessageCreateParamsBase{Model: "m"}, nil, http.Header{}, clientCredentials{})
+			dataLogger.EndSession("req-1")
+
+			// 影子请求结束后会话被写入文件，并发槽位被释放
+			var files []string
+			deadline := time.Now().Add(2 * time.Second)
+			for time.Now().Before(deadline) {
+				files, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+				if len(files) > 0 && len(mirrorSemaphore()) == 0 {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if len(files) != 1 {
+				t.Fatalf("session was not written after the mirror finished")
+			}
+			if n := len(mirrorSemaphore()); n != 0 {
+				t.Fatalf("%d mirror slots still held", n)
+			}
+
+			data, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var session SessionLog
+			if err := json.Unmarshal(data, &session); err != nil {
+				t.Fatal(err)
+			}
+			if session.Mirror == nil {
+				t.Fatalf("mirror result missing from session")
+			}
+			if !strings.Contains(session.Mirror.Error, tt.wantError) || (tt.wantError == "") != (session.Mirror.Error == "") {
+				t.Fatalf("mirror error = %q, want %q", session.Mirror.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+// waitMirrorSlots 占满并发槽位以等待所有影子请求结束，再把槽位还回去
+func waitMirrorSlots(t *testing.T) {
+	t.Helper()
+	slots := mirrorSemaphore()
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < cap(slots); i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-timeout:
+			t.Fatalf("%d mirror slots still held", cap(slots)-i)
+		}
+	}
+	for i := 0; i < cap(slots); i++ {
+		<-slots
+	}
+}
+
+func TestStartMirrorIsolation(t *testing.T) {
+	tests := []struct {
+		name        string
+		logging     bool
+		quarantineA bool
+		wantCalls   []string
+	}{
+		{"not sent without logging", false, false, nil},
+		{"sent with logging", true, false, []string{"1"}},
+		{"quarantined keys are not probed", true, true, []string{"2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []string
+			shadow := &fakeProvider{do: func(ctx context.Context, req *ProviderRequest) (*http.Response, error) {
+				mu.Lock()
+				calls = append(calls, req.APIKey)
+				mu.Unlock()
+				return fakeResponse(http.StatusTooManyRequests, `{"error": "rate limited"}`), nil
+			}}
+			built := useTestUpstreams(t, map[string]Provider{"primary": &fakeProvider{}, "shadow": shadow}, "primary")
+			pool, err := newKeyPool(&KeyPoolConfig{Keys: []PoolKeyConfig{{Name: "a", Key: "1"}, {Name: "b", Key: "2"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			built["shadow"].Keys = pool
+			if tt.quarantineA {
+				pool.keys[0].quarantinedUntil = time.Now().Add(-time.Second)
+			}
+			savedLogger := dataLogger
+			dataLogger = NewDataLogger(LoggingConfig{Enabled: tt.logging, Directory: t.TempDir()})
+			t.Cleanup(func() { dataLogger = savedLogger })
+
+			dataLogger.StartSession("req-1")
+			route := Route{Upstream: built["primary"], Model: "m", Mirror: &RouteMirror{Target: "shadow:m", SampleRate: 1}}
+			startMirror("req-1", route, MessageCreateParamsBase{Model: "m"}, nil, http.Header{}, clientCredentials{VirtualKey: &VirtualKey{ID: "vk_1"}})
+			dataLogger.EndSession("req-1")
+			waitMirrorSlots(t)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.Join(calls, ",") != strings.Join(tt.wantCalls, ",") {
+				t.Fatalf("mirror used keys %v, want %v", calls, tt.wantCalls)
+			}
+			// 影子请求的429不会隔离密钥，隔离期已过的密钥仍留给主流量探测
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			for _, key := range pool.keys {
+				if key.inFlight != 0 || key.probing {
+					t.Fatalf("key %s: inFlight = %d, probing = %v", key.name, key.inFlight, key.probing)
+				}
+			}
+			if !pool.keys[1].quarantinedUntil.IsZero() {
+				t.Fatal("mirror 429 quarantined a key shared with primary traffic")
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModelRule 模型映射规则
+// Match 取值 exact/glob/regex/contains/default，Target 和 Fallbacks 中可以使用 $1、${name} 引用捕获组
+type ModelRule struct {
+	Match     string          `json:"match"`
+	Pattern   string          `json:"pattern,omitempty"`
+	Target    string          `json:"target"`
+	Fallbacks []string        `json:"fallbacks,omitempty"` // 主目标失败时依次尝试的 "upstream:model"
+	Hedge     *HedgeConfig    `json:"hedge,omitempty"`
+	When      *RuleConditions `json:"when,omitempty"`     // 请求特征条件
+	Variants  []RuleVariant   `json:"variants,omitempty"` // A/B分流，设置后代替Target
+	Mirror    *MirrorConfig   `json:"mirror,omitempty"`   // 影子请求
+
+	re     *regexp.Regexp
+	legacy bool // 由旧版model_mappings生成
+}
+
+// modelRuleSet 编译后的有序规则列表
+type modelRuleSet struct {
+	rules       []*ModelRule
+	defaultRule *ModelRule
+}
+
+// modelRuleMatch 规则匹配结果
+type modelRuleMatch struct {
+	Rule      *ModelRule
+	Index     int // 规则在列表中的位置，默认规则为-1
+	Target    string
+	Fallbacks []string
+	Hedge     *RouteHedge
+	Variant   string // A/B分流选中的变体名称
+	Mirror    *RouteMirror
+}
+
+var modelRules *modelRuleSet
+var passthroughRules *modelRuleSet
+
+// initModelRules 编译模型映射规则
+func initModelRules() error {
+	rules, err := compileModelRules(env.ModelRules, env.ModelMappings)
+	if err != nil {
+		return err
+	}
+	passRules, err := compileModelRules(nil, env.Passthrough.ModelMappings)
+	if err != nil {
+		return err
+	}
+	routingMu.Lock()
+	modelRules = rules
+	passthroughRules = passRules
+	routingMu.Unlock()
+	return nil
+}
+
+// compileModelRules 编译规则列表
+// 旧版model_mappings关键词追加在显式规则之后，按关键词长度从长到短排列，保证重叠关键词的匹配结果稳定
+func compileModelRules(configs []ModelRule, legacy map[string]string) (*modelRuleSet, error) {
+	set := &modelRuleSet{}
+	for i := range configs {
+		rule := configs[i]
+		if rule.Pattern == "" && rule.When != nil && rule.Match != "default" {
+			// 只按请求特征匹配的规则适用于所有模型
+			rule.Match = "glob"
+			rule.Pattern = "*"
+		}
+		if rule.Match == "" {
+			rule.Match = "exact"
+		}
+		if rule.Mirror != nil && rule.Mirror.Target == "" {
+			return nil, fmt.Errorf("model rule %d: mirror target is required", i)
+		}
+		if len(rule.Variants) > 0 {
+			if err := validateVariants(rule.Variants); err != nil {
+				return nil, fmt.Errorf("model rule %d: %w", i, err)
+			}
+		}
+		if rule.When != nil {
+			if rule.Match == "default" {
+				return nil, fmt.Errorf("model rule %d: default rule cannot have conditions", i)
+			}
+			conditions := *rule.When
+			if err := conditions.compile(); err != nil {
+				return nil, fmt.Errorf("model rule %d: %w", i, err)
+			}
+			rule.When = &conditions
+		}
+		if rule.Hedge != nil && rule.Hedge.DelayMs <= 0 {
+			return nil, fmt.Errorf("model rule %d: hedge delay_ms must be positive", i)
+		}
+		switch rule.Match {
+		case "exact", "contains":
+		case "glob":
+			re, err := regexp.Compile(globToRegexp(rule.Pattern))
+			if err != nil {
+				return nil, fmt.Errorf("model rule %d: invalid glob %q: %w", i, rule.Pattern, err)
+			}
+			rule.re = re
+		case "regex":
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("model rule %d: invalid regex %q: %w", i, rule.Pattern, err)
+			}
+			rule.re = re
+		case "default":
+			if set.defaultRule != nil {
+				return nil, fmt.Errorf("model rule %d: duplicate default rule", i)
+			}
+			set.defaultRule = &rule
+			continue
+		default:
+			return nil, fmt.Errorf("model rule %d: unknown match type %q", i, rule.Match)
+		}
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("model rule %d: pattern is required", i)
+		}
+		set.rules = append(set.rules, &rule)
+	}
+
+	var keywords []string
+	for keyword := range legacy {
+		keywords = append(keywords, keyword)
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if len(keywords[i]) != len(keywords[j]) {
+			return len(keywords[i]) > len(keywords[j])
+		}
+		return keywords[i] < keywords[j]
+	})
+	for _, keyword := range keywords {
+		set.rules = append(set.rules, &ModelRule{Match: "contains", Pattern: keyword, Target: legacy[keyword], legacy: true})
+	}
+	return set, nil
+}
+
+// globToRegexp 将glob模式转换为锚定的正则表达式，每个 * 和 ? 都是一个捕获组
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString("(.*)")
+		case '?':
+			b.WriteString("(.)")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// matchRule 判断单条规则是否匹配，返回替换捕获组后的目标和备用目标
+func (rule *ModelRule) matchRule(model string, features *requestFeatures) (modelRuleMatch, bool) {
+	expand := func(template string) string { return template }
+	switch rule.Match {
+	case "exact":
+		if model != rule.Pattern {
+			return modelRuleMatch{}, false
+		}
+	case "contains":
+		if !strings.Contains(model, rule.Pattern) {
+			return modelRuleMatch{}, false
+		}
+	case "glob", "regex":
+		submatches := rule.re.FindStringSubmatchIndex(model)
+		if submatches == nil {
+			return modelRuleMatch{}, false
+		}
+		expand = func(template string) string {
+			return string(rule.re.ExpandString(nil, template, model, submatches))
+		}
+	case "default":
+	default:
+		return modelRuleMatch{}, false
+	}
+
+	match := modelRuleMatch{Rule: rule, Target: expand(rule.Target)}
+	if len(rule.Variants) > 0 {
+		variant := selectVariant(rule, features)
+		match.Target = expand(variant.Target)
+		match.Variant = variant.Name
+	}
+	for _, fallback := range rule.Fallbacks {
+		match.Fallbacks = append(match.Fallbacks, expand(fallback))
+	}
+	if rule.Hedge != nil {
+		// 未指定对冲目标时向选中的目标(包括A/B分流的变体)再发一次
+		target := match.Target
+		if rule.Hedge.Target != "" {
+			target = expand(rule.Hedge.Target)
+		}
+		match.Hedge = &RouteHedge{
+			Target: target,
+			Delay:  time.Duration(rule.Hedge.DelayMs) * time.Millisecond,
+		}
+	}
+	if rule.Mirror != nil {
+		sampleRate := rule.Mirror.SampleRate
+		if sampleRate <= 0 {
+			sampleRate = 1
+		}
+		match.Mirror = &RouteMirror{Target: expand(rule.Mirror.Target), SampleRate: sampleRate}
+	}
+	return match, true
+}
+
+// Match 按顺序查找第一条模型名和请求特征都匹配的规则，features为nil时跳过带条件的规则
+// 已包含'/'的模型名视为上游模型ID，只有显式规则可以改写，不再经过旧版关键词映射和默认规则
+func (set *modelRuleSet) Match(model string, features *requestFeatures) (modelRuleMatch, bool) {
+	if set == nil {
+		return modelRuleMatch{}, false
+	}
+	for i, rule := range set.rules {
+		if rule.legacy && strings.Contains(model, "/") {
+			continue
+		}
+		if rule.When != nil && !rule.When.matches(features) {
+			continue
+		}
+		if match, ok := rule.matchRule(model, features); ok {
+			match.Index = i
+			return match, true
+		}
+	}
+	if set.defaultRule != nil && !strings.Contains(model, "/") {
+		match, _ := set.defaultRule.matchRule(model, features)
+		match.Index = -1
+		return match, true
+	}
+	return modelRuleMatch{}, false
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestModelRuleSetMatch(t *testing.T) {
+	rules := []ModelRule{
+		{Match: "exact", Pattern: "claude-opus-4", Target: "a:exact-opus"},
+		{Match: "glob", Pattern: "claude-*-4-?", Target: "b:$1-$2"},
+		{Match: "regex", Pattern: `^gpt-(?P<size>\w+)$`, Target: "c:${size}-model"},
+		{Match: "default", Target: "d:fallback-model"},
+	}
+	legacy := map[string]string{
+		"sonnet":     "legacy-sonnet",
+		"sonnet-4-5": "legacy-sonnet-4-5",
+		"haiku":      "legacy-haiku",
+		"opus":       "legacy-opus",
+	}
+	set, err := compileModelRules(rules, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		model  string
+		want   string
+		wantOK bool
+	}{
+		{"claude-opus-4", "a:exact-opus", true},
+		{"claude-sonnet-4-5", "b:sonnet-5", true}, // 显式规则优先于旧版关键词
+		{"gpt-large", "c:large-model", true},
+		{"my-sonnet-4-5-preview", "legacy-sonnet-4-5", true}, // 较长的关键词先匹配
+		{"my-sonnet-preview", "legacy-sonnet", true},
+		{"claude-opus-3", "legacy-opus", true},
+		{"llama", "d:fallback-model", true},
+		{"vendor/opus", "", false}, // 已经是上游模型ID，不经过关键词和默认规则
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			match, ok := set.Match(tt.model, nil)
+			if ok != tt.wantOK || match.Target != tt.want {
+				t.Fatalf("Match(%q) = %q, %v, want %q, %v", tt.model, match.Target, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCompileModelRulesLegacyOrder(t *testing.T) {
+	legacy := map[string]string{"b": "1", "abc": "2", "ab": "3", "a": "4", "xyz": "5"}
+	// map遍历顺序随机，多次编译的顺序应当相同
+	for round := 0; round < 20; round++ {
+		set, err := compileModelRules(nil, legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, rule := range set.rules {
+			got = append(got, rule.Pattern)
+		}
+		if want := []string{"abc", "xyz", "ab", "a", "b"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCompileModelRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ModelRule
+	}{
+		{"unknown match type", ModelRule{Match: "prefix", Pattern: "x", Target: "y"}},
+		{"invalid regex", ModelRule{Match: "regex", Pattern: "(", Target: "y"}},
+		{"missing pattern", ModelRule{Match: "glob", Target: "y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileModelRules([]ModelRule{tt.rule}, nil); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+	if _, err := compileModelRules([]ModelRule{{Match: "default", Target: "a"}, {Match: "default", Target: "b"}}, nil); err == nil {
+		t.Fatal("expected an error for duplicate default rules")
+	}
+}
+
+func TestMatchRuleHedgeTarget(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ModelRule
+		want string
+	}{
+		{"defaults to target", ModelRule{Match: "glob", Pattern: "m-*", Target: "a:$1", Hedge: &HedgeConfig{DelayMs: 100}}, "a:x"},
+		{"explicit target expands captures", ModelRule{Match: "glob", Pattern: "m-*", Target: "a:$1", Hedge: &HedgeConfig{DelayMs: 100, Target: "b:$1-fast"}}, "b:x-fast"},
+		{"defaults to selected variant", ModelRule{Match: "glob", Pattern: "m-*", Target: "a:unused", Hedge: &HedgeConfig{DelayMs: 100},
+			Variants: []RuleVariant{{Name: "only", Target: "v:$1", Weight: 1}}}, "v:x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := compileModelRules([]ModelRule{tt.rule}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			match, ok := set.Match("m-x", &requestFeatures{APIKey: "k"})
+			if !ok || match.Hedge == nil {
+				t.Fatalf("match = %+v, %v", match, ok)
+			}
+			if match.Hedge.Target != tt.want || match.Hedge.Delay != 100*time.Millisecond {
+				t.Fatalf("hedge = %+v, want target %q", match.Hedge, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	rule := &ModelRule{Match: "glob", Pattern: "*", Variants: []RuleVariant{
+		{Name: "control", Target: "a:m", Weight: 3},
+		{Name: "disabled", Target: "b:m", Weight: 0},
+		{Name: "treatment", Target: "c:m", Weight: 1},
+	}}
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		features := &requestFeatures{UserID: fmt.Sprintf("user-%d", i), APIKey: "shared"}
+		variant := selectVariant(rule, features)
+		// 同一用户总是分到同一变体
+		for j := 0; j < 3; j++ {
+			if again := selectVariant(rule, features); again.Name != variant.Name {
+				t.Fatalf("user-%d got %s then %s", i, variant.Name, again.Name)
+			}
+		}
+		counts[variant.Name]++
+	}
+	if counts["disabled"] != 0 {
+		t.Fatalf("zero-weight variant selected %d times", counts["disabled"])
+	}
+	if ratio := float64(counts["control"]) / 4000; ratio < 0.7 || ratio > 0.8 {
+		t.Fatalf("control share = %.2f, want about 0.75 (%v)", ratio, counts)
+	}
+
+	tests := []struct {
+		name     string
+		a, b     *requestFeatures
+		wantSame bool
+	}{
+		{"user id wins over API key", &requestFeatures{UserID: "u", APIKey: "k1"}, &requestFeatures{UserID: "u", APIKey: "k2"}, true},
+		{"API key without user id", &requestFeatures{APIKey: "k"}, &requestFeatures{APIKey: "k"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := selectVariant(rule, tt.a).Name == selectVariant(rule, tt.b).Name; same != tt.wantSame {
+				t.Fatalf("same variant = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
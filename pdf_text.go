@@ -0,0 +1,967 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfName PDF名称对象，例如 /Type
+type pdfName string
+
+// pdfRef PDF间接引用，例如 12 0 R
+type pdfRef struct {
+	Num int
+}
+
+// pdfDict PDF字典对象
+type pdfDict map[string]interface{}
+
+// pdfOp 内容流中的操作符
+type pdfOp string
+
+// pdfObject PDF间接对象
+type pdfObject struct {
+	Value  interface{}
+	Stream []byte
+}
+
+// pdfDocument 解析后的PDF文档
+type pdfDocument struct {
+	objects map[int]*pdfObject
+	cmaps   map[int]*pdfCMap
+	decoded int // 已解压的字节数，用于限制整个文档的解压总量
+}
+
+// pdfCMap ToUnicode映射表
+type pdfCMap struct {
+	mapping  map[string]string
+	codeLens []int
+}
+
+// pdfPage 页面字典及其(可能继承的)资源
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+var pdfObjectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// PDF来自客户端请求，解析时限制资源占用，防止构造的文档耗尽内存或栈
+const (
+	maxPDFStreamSize   = 16 << 20 // 单个流解压后的最大字节数
+	maxPDFDecodedTotal = 64 << 20 // 整个文档解压的最大总字节数
+	maxPDFNesting      = 64       // 字典和数组的最大嵌套深度
+	maxPDFCMapEntries  = 1 << 18  // 单个ToUnicode映射表的最大条目数
+)
+
+var (
+	errPDFStreamTooLarge = errors.New("PDF stream exceeds the decoded size limit")
+	errPDFTooDeep        = errors.New("PDF objects are nested too deeply")
+)
+
+// extractPDFText 从PDF数据中提取每一页的文本
+// 只支持常见的未加密、FlateDecode压缩的文档，无法识别的内容会被跳过
+// 畸形文档导致的panic转换为错误，调用方回退为占位文本
+func extractPDFText(data []byte) (texts []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			texts, err = nil, fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+	if !bytes.HasPrefix(bytes.TrimSpace(data[:minInt(len(data), 1024)]), []byte("%PDF")) {
+		return nil, errors.New("not a PDF document")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return nil, errors.New("encrypted PDF is not supported")
+	}
+
+	doc := &pdfDocument{
+		objects: make(map[int]*pdfObject),
+		cmaps:   make(map[int]*pdfCMap),
+	}
+	doc.parseObjects(data)
+	doc.expandObjectStreams()
+
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return nil, errors.New("no pages found in PDF")
+	}
+
+	for _, page := range pages {
+		var sb strings.Builder
+		for _, content := range doc.pageContents(page.dict) {
+			doc.extractContentText(&sb, content, page.resources, 0)
+		}
+		texts = append(texts, strings.TrimSpace(sb.String()))
+	}
+	return texts, nil
+}
+
+// parseObjects 扫描文件中的所有 "N G obj" 定义，后出现的定义覆盖先前的(增量更新)
+func (d *pdfDocument) parseObjects(data []byte) {
+	matches := pdfObjectHeader.FindAllSubmatchIndex(data, -1)
+	end := 0
+	for _, m := range matches {
+		if m[0] < end {
+			// 位于上一个对象的流数据内部
+			continue
+		}
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+
+		lx := &pdfLexer{data: data, pos: m[1]}
+		value, err := lx.readValue()
+		if err != nil {
+			continue
+		}
+		obj := &pdfObject{Value: value}
+
+		lx.skipSpace()
+		if bytes.HasPrefix(data[lx.pos:], []byte("stream")) {
+			start := lx.pos + len("stream")
+			if start < len(data) && data[start] == '\r' {
+				start++
+			}
+			if start < len(data) && data[start] == '\n' {
+				start++
+			}
+			stop := -1
+			if dict, ok := value.(pdfDict); ok {
+				if length, ok := dict["Length"].(float64); ok && length >= 0 && length <= float64(len(data)-start) {
+					candidate := start + int(length)
+					if candidate <= len(data) && bytes.Contains(data[candidate:minInt(len(data), candidate+32)], []byte("endstream")) {
+						stop = candidate
+					}
+				}
+			}
+			if stop < 0 {
+				idx := bytes.Index(data[start:], []byte("endstream"))
+				if idx < 0 {
+					continue
+				}
+				stop = start + idx
+				for stop > start && (data[stop-1] == '\n' || data[stop-1] == '\r') {
+					stop--
+				}
+			}
+			obj.Stream = data[start:stop]
+			end = stop
+		} else {
+			end = lx.pos
+		}
+		d.objects[num] = obj
+	}
+}
+
+// expandObjectStreams 展开 /Type /ObjStm 压缩对象流中的对象
+// 先按对象号收集所有对象流再展开：遍历map时插入的键不保证被访问，顺序也不固定，
+// 多个对象流定义同一对象时结果会随运行变化
+func (d *pdfDocument) expandObjectStreams() {
+	var streams []int
+	for num, obj := range d.objects {
+		if dict, ok := obj.Value.(pdfDict); ok && dict["Type"] == pdfName("ObjStm") {
+			streams = append(streams, num)
+		}
+	}
+	sort.Ints(streams)
+
+	for _, streamNum := range streams {
+		obj := d.objects[streamNum]
+		dict := obj.Value.(pdfDict)
+		decoded, err := d.decodeStream(dict, obj.Stream)
+		if err != nil {
+			continue
+		}
+		n, _ := dict["N"].(float64)
+		first, _ := dict["First"].(float64)
+		if first < 0 || first > float64(len(decoded)) {
+			continue
+		}
+
+		header := &pdfLexer{data: decoded[:int(first)]}
+		for i := 0; i < int(n); i++ {
+			numVal, err1 := header.next()
+			offVal, err2 := header.next()
+			if err1 != nil || err2 != nil {
+				break
+			}
+			num, ok1 := numVal.(float64)
+			off, ok2 := offVal.(float64)
+			if !ok1 || !ok2 || off < 0 || off >= float64(len(decoded)) {
+				break
+			}
+			if _, exists := d.objects[int(num)]; exists {
+				continue
+			}
+			pos := int(first) + int(off)
+			if pos >= len(decoded) {
+				continue
+			}
+			lx := &pdfLexer{data: decoded, pos: pos}
+			value, err := lx.readValue()
+			if err != nil {
+				continue
+			}
+			d.objects[int(num)] = &pdfObject{Value: value}
+		}
+	}
+}
+
+// resolve 解析间接引用，返回实际对象值
+func (d *pdfDocument) resolve(v interface{}) interface{} {
+	for depth := 0; depth < 16; depth++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		obj, exists := d.objects[ref.Num]
+		if !exists {
+			return nil
+		}
+		v = obj.Value
+	}
+	return nil
+}
+
+// resolveDict 解析为字典，失败时返回nil
+func (d *pdfDocument) resolveDict(v interface{}) pdfDict {
+	dict, _ := d.resolve(v).(pdfDict)
+	return dict
+}
+
+// pages 按页面树顺序返回所有页面
+func (d *pdfDocument) pages() []pdfPage {
+	var pages []pdfPage
+	visited := make(map[int]bool)
+
+	var walk func(node interface{}, resources pdfDict, depth int)
+	walk = func(node interface{}, resources pdfDict, depth int) {
+		if depth > 64 {
+			return
+		}
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref.Num] {
+				return
+			}
+			visited[ref.Num] = true
+		}
+		dict := d.resolveDict(node)
+		if dict == nil {
+			return
+		}
+		if res := d.resolveDict(dict["Resources"]); res != nil {
+			resources = res
+		}
+		if kids, ok := d.resolve(dict["Kids"]).([]interface{}); ok {
+			for _, kid := range kids {
+				walk(kid, resources, depth+1)
+			}
+			return
+		}
+		if dict["Type"] == pdfName("Page") {
+			pages = append(pages, pdfPage{dict: dict, resources: resources})
+		}
+	}
+
+	for _, obj := range d.objects {
+		dict, ok := obj.Value.(pdfDict)
+		if ok && dict["Type"] == pdfName("Catalog") {
+			walk(dict["Pages"], nil, 0)
+			break
+		}
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	// 找不到目录时，按对象编号顺序回退到所有页面对象
+	var nums []int
+	for num, obj := range d.objects {
+		if dict, ok := obj.Value.(pdfDict); ok && dict["Type"] == pdfName("Page") {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		dict := d.objects[num].Value.(pdfDict)
+		pages = append(pages, pdfPage{dict: dict, resources: d.resolveDict(dict["Resources"])})
+	}
+	return pages
+}
+
+// pageContents 返回页面的解码后内容流
+func (d *pdfDocument) pageContents(page pdfDict) [][]byte {
+	var refs []interface{}
+	switch contents := page["Contents"].(type) {
+	case pdfRef:
+		if arr, ok := d.resolve(contents).([]interface{}); ok {
+			refs = arr
+		} else {
+			refs = []interface{}{contents}
+		}
+	case []interface{}:
+		refs = contents
+	}
+
+	var streams [][]byte
+	for _, ref := range refs {
+		if decoded := d.streamOf(ref); decoded != nil {
+			streams = append(streams, decoded)
+		}
+	}
+	return streams
+}
+
+// streamOf 解码引用指向的流对象
+func (d *pdfDocument) streamOf(v interface{}) []byte {
+	ref, ok := v.(pdfRef)
+	if !ok {
+		return nil
+	}
+	obj, exists := d.objects[ref.Num]
+	if !exists || obj.Stream == nil {
+		return nil
+	}
+	dict, _ := obj.Value.(pdfDict)
+	decoded, err := d.decodeStream(dict, obj.Stream)
+	if err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// decodeStream 解码流数据并计入文档的解压总量，超出限制后不再解码
+func (d *pdfDocument) decodeStream(dict pdfDict, raw []byte) ([]byte, error) {
+	if d.decoded >= maxPDFDecodedTotal {
+		return nil, errPDFStreamTooLarge
+	}
+	limit := maxPDFDecodedTotal - d.decoded
+	if limit > maxPDFStreamSize {
+		limit = maxPDFStreamSize
+	}
+	decoded, err := decodePDFStream(dict, raw, limit)
+	if err != nil {
+		return nil, err
+	}
+	d.decoded += len(decoded)
+	return decoded, nil
+}
+
+// fontCMaps 返回资源字典中各字体的ToUnicode映射
+func (d *pdfDocument) fontCMaps(resources pdfDict) map[string]*pdfCMap {
+	fonts := make(map[string]*pdfCMap)
+	if resources == nil {
+		return fonts
+	}
+	for name, fontRef := range d.resolveDict(resources["Font"]) {
+		font := d.resolveDict(fontRef)
+		if font == nil {
+			continue
+		}
+		ref, ok := font["ToUnicode"].(pdfRef)
+		if !ok {
+			continue
+		}
+		if cmap, cached := d.cmaps[ref.Num]; cached {
+			fonts[name] = cmap
+			continue
+		}
+		var cmap *pdfCMap
+		if data := d.streamOf(ref); data != nil {
+			cmap = parsePDFCMap(data)
+		}
+		d.cmaps[ref.Num] = cmap
+		fonts[name] = cmap
+	}
+	return fonts
+}
+
+// extractContentText 解释内容流中的文本操作符并写入文本
+func (d *pdfDocument) extractContentText(sb *strings.Builder, content []byte, resources pdfDict, depth int) {
+	if depth > 8 {
+		return
+	}
+	fonts := d.fontCMaps(resources)
+	var cmap *pdfCMap
+	var operands []interface{}
+	lastY := 0.0
+
+	newline := func() {
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	space := func() {
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), " ") && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString(" ")
+		}
+	}
+
+	lx := &pdfLexer{data: content}
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			break
+		}
+		op, isOp := tok.(pdfOp)
+		if !isOp {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) >= 1 {
+				if name, ok := operands[0].(pdfName); ok {
+					cmap = fonts[string(name)]
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, _ := operands[1].(float64); ty != 0 {
+					newline()
+				}
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				y, _ := operands[5].(float64)
+				if y != lastY {
+					newline()
+				}
+				lastY = y
+			}
+		case "T*":
+			newline()
+		case "Tj":
+			if len(operands) >= 1 {
+				if s, ok := operands[0].(string); ok {
+					sb.WriteString(cmap.decode(s))
+				}
+			}
+		case "'":
+			newline()
+			if len(operands) >= 1 {
+				if s, ok := operands[0].(string); ok {
+					sb.WriteString(cmap.decode(s))
+				}
+			}
+		case "\"":
+			newline()
+			if len(operands) >= 3 {
+				if s, ok := operands[2].(string); ok {
+					sb.WriteString(cmap.decode(s))
+				}
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				if arr, ok := operands[0].([]interface{}); ok {
+					for _, item := range arr {
+						switch v := item.(type) {
+						case string:
+							sb.WriteString(cmap.decode(v))
+						case float64:
+							if v < -180 {
+								space()
+							}
+						}
+					}
+				}
+			}
+		case "Do":
+			if len(operands) >= 1 && resources != nil {
+				if name, ok := operands[0].(pdfName); ok {
+					xobjects := d.resolveDict(resources["XObject"])
+					ref := xobjects[string(name)]
+					if form := d.resolveDict(ref); form != nil && form["Subtype"] == pdfName("Form") {
+						formResources := d.resolveDict(form["Resources"])
+						if formResources == nil {
+							formResources = resources
+						}
+						if data := d.streamOf(ref); data != nil {
+							d.extractContentText(sb, data, formResources, depth+1)
+						}
+					}
+				}
+			}
+		case "BI":
+			lx.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+}
+
+// decode 使用ToUnicode映射解码字符串，无映射时按Latin-1处理
+func (m *pdfCMap) decode(s string) string {
+	if m == nil || len(m.mapping) == 0 {
+		if strings.HasPrefix(s, "\xfe\xff") {
+			return decodeUTF16BE([]byte(s[2:]))
+		}
+		var sb strings.Builder
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			if c >= 0x20 || c == '\t' {
+				sb.WriteRune(rune(c))
+			}
+		}
+		return sb.String()
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		matched := false
+		for _, l := range m.codeLens {
+			if i+l > len(s) {
+				continue
+			}
+			if text, ok := m.mapping[s[i:i+l]]; ok {
+				sb.WriteString(text)
+				i += l
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			i += m.codeLens[0]
+		}
+	}
+	return sb.String()
+}
+
+// parsePDFCMap 解析ToUnicode CMap中的bfchar和bfrange定义
+func parsePDFCMap(data []byte) *pdfCMap {
+	cmap := &pdfCMap{mapping: make(map[string]string)}
+	lens := make(map[int]bool)
+	lx := &pdfLexer{data: data}
+
+	var operands []interface{}
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			break
+		}
+		op, isOp := tok.(pdfOp)
+		if !isOp {
+			operands = append(operands, tok)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				if lo, ok := operands[i].(string); ok && len(lo) > 0 {
+					lens[len(lo)] = true
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(string)
+				dst, ok2 := operands[i+1].(string)
+				if ok1 && ok2 && len(src) > 0 {
+					cmap.mapping[src] = decodeUTF16BE([]byte(dst))
+					lens[len(src)] = true
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(string)
+				hi, ok2 := operands[i+1].(string)
+				if !ok1 || !ok2 || len(lo) == 0 || len(lo) != len(hi) {
+					continue
+				}
+				lens[len(lo)] = true
+				start, stop := pdfCodeValue(lo), pdfCodeValue(hi)
+				if stop < start || stop-start > 0xffff {
+					continue
+				}
+				if len(cmap.mapping)+stop-start >= maxPDFCMapEntries {
+					continue
+				}
+				for code := start; code <= stop; code++ {
+					offset := code - start
+					key := pdfCodeBytes(code, len(lo))
+					switch dst := operands[i+2].(type) {
+					case string:
+						units := bytesToUTF16(dst)
+						if len(units) == 0 {
+							continue
+						}
+						units[len(units)-1] += uint16(offset)
+						cmap.mapping[key] = string(utf16.Decode(units))
+					case []interface{}:
+						if offset < len(dst) {
+							if s, ok := dst[offset].(string); ok {
+								cmap.mapping[key] = decodeUTF16BE([]byte(s))
+							}
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+
+	for l := range lens {
+		cmap.codeLens = append(cmap.codeLens, l)
+	}
+	sort.Ints(cmap.codeLens)
+	if len(cmap.codeLens) == 0 {
+		cmap.codeLens = []int{1}
+	}
+	return cmap
+}
+
+// pdfCodeValue 将大端字节序的字符编码转换为整数
+func pdfCodeValue(s string) int {
+	v := 0
+	for i := 0; i < len(s); i++ {
+		v = v<<8 | int(s[i])
+	}
+	return v
+}
+
+// pdfCodeBytes 将整数编码转换为指定长度的大端字节序字符串
+func pdfCodeBytes(v, n int) string {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return string(b)
+}
+
+// bytesToUTF16 将UTF-16BE字节转换为码元
+func bytesToUTF16(s string) []uint16 {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return units
+}
+
+// decodeUTF16BE 解码UTF-16BE字节
+func decodeUTF16BE(b []byte) string {
+	return string(utf16.Decode(bytesToUTF16(string(b))))
+}
+
+// decodePDFStream 按 /Filter 解码流数据，目前仅支持FlateDecode
+// 每一级解压结果超过limit字节时返回错误
+func decodePDFStream(dict pdfDict, raw []byte, limit int) ([]byte, error) {
+	var filters []interface{}
+	switch f := dict["Filter"].(type) {
+	case pdfName:
+		filters = []interface{}{f}
+	case []interface{}:
+		filters = f
+	}
+
+	data := raw
+	for _, f := range filters {
+		switch f {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			decoded, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+			r.Close()
+			if len(decoded) > limit {
+				return nil, errPDFStreamTooLarge
+			}
+			if err != nil && len(decoded) == 0 {
+				return nil, err
+			}
+			data = decoded
+		default:
+			return nil, errors.New("unsupported PDF stream filter")
+		}
+	}
+	return data, nil
+}
+
+// pdfLexer PDF对象和内容流的词法分析器
+type pdfLexer struct {
+	data  []byte
+	pos   int
+	depth int // 当前字典和数组的嵌套深度
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// skipSpace 跳过空白和注释
+func (lx *pdfLexer) skipSpace() {
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		if c == '%' {
+			for lx.pos < len(lx.data) && lx.data[lx.pos] != '\n' && lx.data[lx.pos] != '\r' {
+				lx.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		lx.pos++
+	}
+}
+
+// readValue 读取一个值，并把 "N G R" 组合为间接引用
+func (lx *pdfLexer) readValue() (interface{}, error) {
+	v, err := lx.next()
+	if err != nil {
+		return nil, err
+	}
+	num, ok := v.(float64)
+	if !ok {
+		return v, nil
+	}
+
+	saved := lx.pos
+	gen, err1 := lx.next()
+	op, err2 := lx.next()
+	if err1 == nil && err2 == nil {
+		if _, isNum := gen.(float64); isNum && op == pdfOp("R") {
+			return pdfRef{Num: int(num)}, nil
+		}
+	}
+	lx.pos = saved
+	return v, nil
+}
+
+// next 读取下一个词法单元；操作符以pdfOp返回
+func (lx *pdfLexer) next() (interface{}, error) {
+	lx.skipSpace()
+	if lx.pos >= len(lx.data) {
+		return nil, io.EOF
+	}
+
+	c := lx.data[lx.pos]
+	switch c {
+	case '/':
+		lx.pos++
+		start := lx.pos
+		for lx.pos < len(lx.data) && !isPDFSpace(lx.data[lx.pos]) && !isPDFDelimiter(lx.data[lx.pos]) {
+			lx.pos++
+		}
+		return pdfName(lx.data[start:lx.pos]), nil
+	case '(':
+		lx.pos++
+		return lx.readLiteral(), nil
+	case '<':
+		if lx.pos+1 < len(lx.data) && lx.data[lx.pos+1] == '<' {
+			if lx.depth >= maxPDFNesting {
+				lx.pos = len(lx.data)
+				return nil, errPDFTooDeep
+			}
+			lx.pos += 2
+			lx.depth++
+			dict := lx.readDict()
+			lx.depth--
+			return dict, nil
+		}
+		lx.pos++
+		return lx.readHex(), nil
+	case '[':
+		if lx.depth >= maxPDFNesting {
+			lx.pos = len(lx.data)
+			return nil, errPDFTooDeep
+		}
+		lx.pos++
+		lx.depth++
+		arr := lx.readArray()
+		lx.depth--
+		return arr, nil
+	case ']', '>', ')', '{', '}':
+		lx.pos++
+		return pdfOp(string(c)), nil
+	}
+
+	start := lx.pos
+	for lx.pos < len(lx.data) && !isPDFSpace(lx.data[lx.pos]) && !isPDFDelimiter(lx.data[lx.pos]) {
+		lx.pos++
+	}
+	tok := string(lx.data[start:lx.pos])
+	if n, err := strconv.ParseFloat(tok, 64); err == nil {
+		return n, nil
+	}
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	return pdfOp(tok), nil
+}
+
+// readDict 读取 << ... >> 字典
+func (lx *pdfLexer) readDict() pdfDict {
+	dict := make(pdfDict)
+	for {
+		lx.skipSpace()
+		if lx.pos >= len(lx.data) {
+			return dict
+		}
+		if lx.data[lx.pos] == '>' {
+			lx.pos++
+			if lx.pos < len(lx.data) && lx.data[lx.pos] == '>' {
+				lx.pos++
+			}
+			return dict
+		}
+		key, err := lx.next()
+		if err != nil {
+			return dict
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			continue
+		}
+		value, err := lx.readValue()
+		if err != nil {
+			return dict
+		}
+		dict[string(name)] = value
+	}
+}
+
+// readArray 读取 [ ... ] 数组
+func (lx *pdfLexer) readArray() []interface{} {
+	arr := []interface{}{}
+	for {
+		lx.skipSpace()
+		if lx.pos >= len(lx.data) {
+			return arr
+		}
+		if lx.data[lx.pos] == ']' {
+			lx.pos++
+			return arr
+		}
+		value, err := lx.readValue()
+		if err != nil {
+			return arr
+		}
+		arr = append(arr, value)
+	}
+}
+
+// readLiteral 读取 ( ... ) 字符串，处理嵌套括号和转义
+func (lx *pdfLexer) readLiteral() string {
+	var buf bytes.Buffer
+	depth := 1
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		lx.pos++
+		switch c {
+		case '(':
+			depth++
+			buf.WriteByte(c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return buf.String()
+			}
+			buf.WriteByte(c)
+		case '\\':
+			if lx.pos >= len(lx.data) {
+				return buf.String()
+			}
+			e := lx.data[lx.pos]
+			lx.pos++
+			switch e {
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case 'b':
+				buf.WriteByte('\b')
+			case 'f':
+				buf.WriteByte('\f')
+			case '\r':
+				if lx.pos < len(lx.data) && lx.data[lx.pos] == '\n' {
+					lx.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && lx.pos < len(lx.data) && lx.data[lx.pos] >= '0' && lx.data[lx.pos] <= '7'; i++ {
+						v = v*8 + int(lx.data[lx.pos]-'0')
+						lx.pos++
+					}
+					buf.WriteByte(byte(v))
+				} else {
+					buf.WriteByte(e)
+				}
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// readHex 读取 < ... > 十六进制字符串
+func (lx *pdfLexer) readHex() string {
+	var digits []byte
+	for lx.pos < len(lx.data) && lx.data[lx.pos] != '>' {
+		c := lx.data[lx.pos]
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+		lx.pos++
+	}
+	lx.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	return string(out)
+}
+
+// skipInlineImage 跳过内容流中的内联图像数据 (BI ... ID ... EI)
+func (lx *pdfLexer) skipInlineImage() {
+	idx := bytes.Index(lx.data[lx.pos:], []byte("ID"))
+	if idx < 0 {
+		lx.pos = len(lx.data)
+		return
+	}
+	lx.pos += idx + 2
+	for lx.pos+2 < len(lx.data) {
+		if isPDFSpace(lx.data[lx.pos]) && lx.data[lx.pos+1] == 'E' && lx.data[lx.pos+2] == 'I' &&
+			(lx.pos+3 >= len(lx.data) || isPDFSpace(lx.data[lx.pos+3])) {
+			lx.pos += 3
+			return
+		}
+		lx.pos++
+	}
+	lx.pos = len(lx.data)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF 按顺序拼接对象生成PDF，objects[i]为第i+1号对象的内容
+func buildPDF(objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+// pdfStream 生成流对象
+func pdfStream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// simplePDF 单页文档，content为页面内容流对象
+func simplePDF(content string) []byte {
+	return buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		content,
+	)
+}
+
+func TestExtractPDFText(t *testing.T) {
+	content := []byte("BT /F1 12 Tf 72 720 Td (Hello) Tj 0 -14 Td [(Wor) -50 (ld)] TJ ET")
+	tests := []struct {
+		name string
+		pdf  []byte
+		want string
+	}{
+		{"plain stream", simplePDF(pdfStream("", content)), "Hello\nWorld"},
+		{"flate stream", simplePDF(pdfStream("/Filter /FlateDecode", deflate(t, content))), "Hello\nWorld"},
+		{"wrong length falls back to endstream", simplePDF("<< /Length 9999 >>\nstream\n" + string(content) + "\nendstream"), "Hello\nWorld"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := extractPDFText(tt.pdf)
+			if err != nil {
+				t.Fatalf("extractPDFText: %v", err)
+			}
+			if len(pages) != 1 || pages[0] != tt.want {
+				t.Fatalf("pages = %q, want [%q]", pages, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPDFTextMalformed(t *testing.T) {
+	content := "BT (ok) Tj ET"
+	objStm := func(dict, body string) string {
+		return pdfStream("/Type /ObjStm "+dict, []byte(body))
+	}
+	tests := []struct {
+		name string
+		pdf  []byte
+	}{
+		{"not a pdf", []byte("hello")},
+		{"negative length", simplePDF("<< /Length -5 >>\nstream\n" + content + "\nendstream")},
+		{"huge length", simplePDF("<< /Length 1e300 >>\nstream\n" + content + "\nendstream")},
+		{"object stream negative first", append(simplePDF(pdfStream("", []byte(content))), []byte(
+			"9 0 obj\n"+objStm("/N 1 /First -5", "10 0 << /Type /Page >>")+"\nendobj\n")...)},
+		{"object stream negative offset", append(simplePDF(pdfStream("", []byte(content))), []byte(
+			"9 0 obj\n"+objStm("/N 1 /First 5", "10 -3 << /Type /Page >>")+"\nendobj\n")...)},
+		{"object stream first past end", append(simplePDF(pdfStream("", []byte(content))), []byte(
+			"9 0 obj\n"+objStm("/N 1 /First 99999", "10 0")+"\nendobj\n")...)},
+		{"deeply nested arrays", simplePDF(strings.Repeat("[", 1000000))},
+		{"deeply nested dicts", simplePDF(strings.Repeat("<< /A ", 1000000))},
+		{"nested arrays in content", simplePDF(pdfStream("", []byte("BT "+strings.Repeat("[", 1000000)+" TJ ET")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 能否提取出文本取决于文档其余部分，但边界检查应当在触发panic之前拦截畸形数据
+			if _, err := extractPDFText(tt.pdf); err != nil && strings.HasPrefix(err.Error(), "malformed PDF") {
+				t.Fatalf("parser panicked: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractPDFTextObjectStreams(t *testing.T) {
+	// objStm 生成只包含一个对象的对象流
+	objStm := func(num int, body string) string {
+		header := fmt.Sprintf("%d 0 ", num)
+		return pdfStream(fmt.Sprintf("/Type /ObjStm /N 1 /First %d", len(header)), []byte(header+body))
+	}
+	tests := []struct {
+		name    string
+		objects []string
+		want    string
+	}{
+		{"page in object stream", []string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [9 0 R] /Count 1 >>",
+			pdfStream("", []byte("BT (Hello) Tj ET")),
+			objStm(9, "<< /Type /Page /Parent 2 0 R /Contents 3 0 R >>"),
+		}, "Hello"},
+		{"lowest object stream wins for duplicate objects", []string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [9 0 R] /Count 1 >>",
+			pdfStream("", []byte("BT (First) Tj ET")),
+			objStm(9, "<< /Type /Page /Parent 2 0 R /Contents 3 0 R >>"),
+			objStm(9, "<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>"),
+			pdfStream("", []byte("BT (Second) Tj ET")),
+		}, "First"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdf := buildPDF(tt.objects...)
+			// 结果不能随map遍历顺序变化
+			for i := 0; i < 20; i++ {
+				pages, err := extractPDFText(pdf)
+				if err != nil {
+					t.Fatalf("extractPDFText: %v", err)
+				}
+				if len(pages) != 1 || pages[0] != tt.want {
+					t.Fatalf("run %d: pages = %q, want [%q]", i, pages, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodePDFStreamLimit(t *testing.T) {
+	bomb := deflate(t, make([]byte, 4<<20))
+	tests := []struct {
+		name    string
+		limit   int
+		wantErr bool
+	}{
+		{"within limit", 4 << 20, false},
+		{"exceeds limit", 1 << 20, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := decodePDFStream(pdfDict{"Filter": pdfName("FlateDecode")}, bomb, tt.limit)
+			if tt.wantErr {
+				if err != errPDFStreamTooLarge {
+					t.Fatalf("err = %v, want errPDFStreamTooLarge", err)
+				}
+				return
+			}
+			if err != nil || len(decoded) != 4<<20 {
+				t.Fatalf("decoded %d bytes, err %v", len(decoded), err)
+			}
+		})
+	}
+}
+
+func TestPDFDocumentDecodeBudget(t *testing.T) {
+	stream := deflate(t, make([]byte, maxPDFStreamSize))
+	doc := &pdfDocument{objects: make(map[int]*pdfObject), cmaps: make(map[int]*pdfCMap)}
+	dict := pdfDict{"Filter": pdfName("FlateDecode")}
+	decodedStreams := 0
+	for i := 0; i < maxPDFDecodedTotal/maxPDFStreamSize+2; i++ {
+		if _, err := doc.decodeStream(dict, stream); err == nil {
+			decodedStreams++
+		}
+	}
+	if want := maxPDFDecodedTotal / maxPDFStreamSize; decodedStreams != want {
+		t.Fatalf("decoded %d streams, want %d before the document budget is exhausted", decodedStreams, want)
+	}
+}
+
+// valueDepth 返回解析结果中字典和数组的嵌套深度
+func valueDepth(v interface{}) int {
+	depth := 0
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if d := valueDepth(item); d > depth {
+				depth = d
+			}
+		}
+		return depth + 1
+	case pdfDict:
+		for _, item := range v {
+			if d := valueDepth(item); d > depth {
+				depth = d
+			}
+		}
+		return depth + 1
+	}
+	return 0
+}
+
+func TestPDFLexerNesting(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"shallow array", strings.Repeat("[", 10) + strings.Repeat("]", 10), 10},
+		{"at limit", strings.Repeat("[", maxPDFNesting) + strings.Repeat("]", maxPDFNesting), maxPDFNesting},
+		{"arrays over limit are truncated", strings.Repeat("[", 1000000), maxPDFNesting},
+		{"dicts over limit are truncated", strings.Repeat("<< /A ", 1000000), maxPDFNesting},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lx := &pdfLexer{data: []byte(tt.input)}
+			v, err := lx.next()
+			if err != nil {
+				t.Fatalf("next: %v", err)
+			}
+			if got := valueDepth(v); got != tt.want {
+				t.Fatalf("depth = %d, want %d", got, tt.want)
+			}
+			if lx.depth != 0 {
+				t.Fatalf("lexer depth = %d after reading, want 0", lx.depth)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeyPolicy 虚拟密钥的请求策略，在请求转换之前检查
+type KeyPolicy struct {
+	Models            []string `json:"models,omitempty"`              // 允许客户端请求的模型名或别名，支持 * 和 ? 通配符；为空表示不限制
+	Targets           []string `json:"targets,omitempty"`             // 允许路由到的上游模型名或 "上游:模型"，支持通配符；为空表示信任路由规则
+	MaxTokens         int      `json:"max_tokens,omitempty"`          // max_tokens上限
+	MaxThinkingBudget int      `json:"max_thinking_budget,omitempty"` // thinking.budget_tokens上限
+	AllowTools        *bool    `json:"allow_tools,omitempty"`         // 为false时不允许携带工具
+	AllowImages       *bool    `json:"allow_images,omitempty"`        // 为false时不允许图片输入
+	SystemPrefix      string   `json:"system_prefix,omitempty"`       // 强制添加在system提示词最前面的内容
+
+	modelRes  []*regexp.Regexp
+	targetRes []*regexp.Regexp
+}
+
+// policyError 请求违反密钥策略
+type policyError struct {
+	message string
+}
+
+func (e *policyError) Error() string {
+	return e.message
+}
+
+// compile 编译模型和目标通配符
+func (p *KeyPolicy) compile() error {
+	var err error
+	if p.modelRes, err = compileGlobs(p.Models, "model"); err != nil {
+		return err
+	}
+	p.targetRes, err = compileGlobs(p.Targets, "target")
+	return err
+}
+
+// compileGlobs 把通配符模式编译为正则表达式
+func compileGlobs(patterns []string, kind string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(globToRegexp(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %w", kind, pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// matchesAny 判断名称是否匹配任一模式
+func matchesAny(res []*regexp.Regexp, name string) bool {
+	for _, re := range res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsModel 判断策略是否允许请求的模型
+func (p *KeyPolicy) allowsModel(model string) bool {
+	return len(p.modelRes) == 0 || matchesAny(p.modelRes, model)
+}
+
+// allowsTarget 判断策略是否允许发往上游目标，模式匹配上游模型名或 "上游:模型"
+func (p *KeyPolicy) allowsTarget(upstream *Upstream, model string) bool {
+	if len(p.targetRes) == 0 || matchesAny(p.targetRes, model) {
+		return true
+	}
+	return upstream != nil && matchesAny(p.targetRes, upstream.Name+":"+model)
+}
+
+// Restrict 按目标白名单过滤路由解析后的目标
+// models只约束客户端请求的别名，路由允许的别名得到的目标由运维配置的规则决定，默认都允许；
+// 配置了targets时，规则、A/B分流、预算降级等改写出的主目标、备用目标、对冲和影子目标逐一检查：
+// 不允许的备用目标被移除，对冲和影子请求被取消，没有可用目标时返回错误
+func (p *KeyPolicy) Restrict(route Route) (Route, error) {
+	if p == nil || len(p.targetRes) == 0 {
+		return route, nil
+	}
+	var allowed []RouteTarget
+	for _, target := range route.Targets() {
+		if p.allowsTarget(target.Upstream, target.Model) {
+			allowed = append(allowed, target)
+		}
+	}
+	if len(allowed) == 0 {
+		return route, &policyError{fmt.Sprintf("This API key is not allowed to use upstream model %q (allowed targets: %s)", route.Model, strings.Join(p.Targets, ", "))}
+	}
+	route.Upstream, route.Model, route.PreferredKey = allowed[0].Upstream, allowed[0].Model, allowed[0].PreferredKey
+	route.Fallbacks = allowed[1:]
+	if route.Hedge != nil && !p.allowsTarget(splitUpstreamTarget(route.Hedge.Target)) {
+		route.Hedge = nil
+	}
+	if route.Mirror != nil && !p.allowsTarget(splitUpstreamTarget(route.Mirror.Target)) {
+		route.Mirror = nil
+	}
+	return route, nil
+}
+
+// Check 检查请求是否符合策略
+func (p *KeyPolicy) Check(request MessageCreateParamsBase) error {
+	if p == nil {
+		return nil
+	}
+	if !p.allowsModel(request.Model) {
+		return &policyError{fmt.Sprintf("This API key is not allowed to use model %q (allowed: %s)", request.Model, strings.Join(p.Models, ", "))}
+	}
+	if p.MaxTokens > 0 && request.MaxTokens > p.MaxTokens {
+		return &policyError{fmt.Sprintf("max_tokens %d exceeds the limit of %d for this API key", request.MaxTokens, p.MaxTokens)}
+	}
+	if p.MaxThinkingBudget > 0 && request.Thinking != nil && request.Thinking.Type == "enabled" &&
+		request.Thinking.BudgetTokens > p.MaxThinkingBudget {
+		return &policyError{fmt.Sprintf("thinking.budget_tokens %d exceeds the limit of %d for this API key", request.Thinking.BudgetTokens, p.MaxThinkingBudget)}
+	}
+	if p.AllowTools != nil && !*p.AllowTools && len(request.Tools) > 0 {
+		return &policyError{"This API key is not allowed to use tools"}
+	}
+	if p.AllowImages != nil && !*p.AllowImages && hasImages(request) {
+		return &policyError{"This API key is not allowed to send images"}
+	}
+	return nil
+}
+
+// hasImages 判断请求中是否包含图片，包括工具结果中的图片
+func hasImages(request MessageCreateParamsBase) bool {
+	for _, message := range request.Messages {
+		for _, block := range contentBlocks(message.Content) {
+			if block.Type == "image" {
+				return true
+			}
+			if block.Type == "tool_result" {
+				for _, inner := range contentBlocks(block.Content) {
+					if inner.Type == "image" {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Apply 按策略改写请求：在system提示词最前面加上强制前缀
+// 同时改写原始请求体，使透传上游也能生效
+func (p *KeyPolicy) Apply(request *MessageCreateParamsBase, body []byte) ([]byte, error) {
+	if p == nil || p.SystemPrefix == "" {
+		return body, nil
+	}
+	switch system := request.System.(type) {
+	case nil:
+		request.System = p.SystemPrefix
+	case string:
+		if system == "" {
+			request.System = p.SystemPrefix
+		} else {
+			request.System = p.SystemPrefix + "\n\n" + system
+		}
+	case []interface{}:
+		// 作为单独的文本块放在最前面，保留后续块上的cache_control
+		prefixBlock := map[string]interface{}{"type": "text", "text": p.SystemPrefix}
+		request.System = append([]interface{}{prefixBlock}, system...)
+	default:
+		return nil, fmt.Errorf("unsupported system prompt type %T", request.System)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	system, err := json.Marshal(request.System)
+	if err != nil {
+		return nil, err
+	}
+	raw["system"] = system
+	return json.Marshal(raw)
+}
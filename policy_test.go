@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func compiledPolicy(t *testing.T, policy KeyPolicy) *KeyPolicy {
+	t.Helper()
+	if err := policy.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	return &policy
+}
+
+func TestKeyPolicyCheck(t *testing.T) {
+	no := false
+	policy := compiledPolicy(t, KeyPolicy{
+		Models:            []string{"*haiku*", "claude-sonnet-?"},
+		MaxTokens:         1000,
+		MaxThinkingBudget: 500,
+		AllowTools:        &no,
+		AllowImages:       &no,
+	})
+	image := []interface{}{map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64"}}}
+	toolResultImage := []interface{}{map[string]interface{}{"type": "tool_result", "tool_use_id": "t1", "content": image}}
+	tests := []struct {
+		name    string
+		request MessageCreateParamsBase
+		wantErr bool
+	}{
+		{"allowed glob", MessageCreateParamsBase{Model: "claude-3-haiku", MaxTokens: 100}, false},
+		{"single character wildcard", MessageCreateParamsBase{Model: "claude-sonnet-4"}, false},
+		{"wildcard does not span", MessageCreateParamsBase{Model: "claude-sonnet-45"}, true},
+		{"model not allowed", MessageCreateParamsBase{Model: "claude-opus-4"}, true},
+		{"max tokens", MessageCreateParamsBase{Model: "haiku", MaxTokens: 1001}, true},
+		{"thinking budget", MessageCreateParamsBase{Model: "haiku", Thinking: &ThinkingConfig{Type: "enabled", BudgetTokens: 501}}, true},
+		{"disabled thinking ignores budget", MessageCreateParamsBase{Model: "haiku", Thinking: &ThinkingConfig{Type: "disabled", BudgetTokens: 501}}, false},
+		{"tools", MessageCreateParamsBase{Model: "haiku", Tools: []Tool{{Name: "t"}}}, true},
+		{"image", MessageCreateParamsBase{Model: "haiku", Messages: []Message{{Role: "user", Content: image}}}, true},
+		{"image in tool result", MessageCreateParamsBase{Model: "haiku", Messages: []Message{{Role: "user", Content: toolResultImage}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := policy.Check(tt.request); (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyPolicyRestrict(t *testing.T) {
+	built := useTestUpstreams(t, map[string]Provider{
+		"cheap":   &fakeProvider{},
+		"premium": &fakeProvider{},
+	}, "cheap")
+	cheap, premium := built["cheap"], built["premium"]
+
+	tests := []struct {
+		name        string
+		models      []string
+		targets     []string
+		route       Route
+		wantTargets []RouteTarget
+		wantHedge   bool
+		wantMirror  bool
+		wantErr     bool
+	}{
+		{
+			name:        "no allowlist keeps route",
+			route:       Route{Upstream: premium, Model: "opus", Hedge: &RouteHedge{Target: "premium:opus"}},
+			wantTargets: []RouteTarget{{Upstream: premium, Model: "opus"}},
+			wantHedge:   true,
+		},
+		{
+			name:        "models allowlist does not restrict routed targets",
+			models:      []string{"claude-*-haiku-*"},
+			route:       Route{Upstream: premium, Model: "anthropic/claude-3.5-haiku", Fallbacks: []RouteTarget{{Upstream: cheap, Model: "gpt-4o-mini"}}},
+			wantTargets: []RouteTarget{{Upstream: premium, Model: "anthropic/claude-3.5-haiku"}, {Upstream: cheap, Model: "gpt-4o-mini"}},
+		},
+		{
+			name:        "targets allowlist checked independently of models",
+			models:      []string{"claude-*-haiku-*"},
+			targets:     []string{"*claude-3.5-haiku"},
+			route:       Route{Upstream: premium, Model: "anthropic/claude-3.5-haiku", Fallbacks: []RouteTarget{{Upstream: cheap, Model: "gpt-4o-mini"}}},
+			wantTargets: []RouteTarget{{Upstream: premium, Model: "anthropic/claude-3.5-haiku"}},
+		},
+		{
+			name:        "rewritten primary is dropped and fallback promoted",
+			targets:     []string{"*haiku*"},
+			route:       Route{Upstream: premium, Model: "opus", Fallbacks: []RouteTarget{{Upstream: cheap, Model: "haiku-3"}}},
+			wantTargets: []RouteTarget{{Upstream: cheap, Model: "haiku-3"}},
+		},
+		{
+			name:        "disallowed fallback is removed",
+			targets:     []string{"*haiku*"},
+			route:       Route{Upstream: cheap, Model: "haiku-3", Fallbacks: []RouteTarget{{Upstream: premium, Model: "opus"}}},
+			wantTargets: []RouteTarget{{Upstream: cheap, Model: "haiku-3"}},
+		},
+		{
+			name:        "upstream-qualified pattern",
+			targets:     []string{"cheap:*"},
+			route:       Route{Upstream: cheap, Model: "anything", Fallbacks: []RouteTarget{{Upstream: premium, Model: "anything"}}},
+			wantTargets: []RouteTarget{{Upstream: cheap, Model: "anything"}},
+		},
+		{
+			name:        "hedge and mirror to disallowed models are cancelled",
+			targets:     []string{"*haiku*"},
+			route:       Route{Upstream: cheap, Model: "haiku-3", Hedge: &RouteHedge{Target: "premium:opus"}, Mirror: &RouteMirror{Target: "premium:opus"}},
+			wantTargets: []RouteTarget{{Upstream: cheap, Model: "haiku-3"}},
+		},
+		{
+			name:        "allowed hedge and mirror are kept",
+			targets:     []string{"*haiku*"},
+			route:       Route{Upstream: cheap, Model: "haiku-3", Hedge: &RouteHedge{Target: "premium:haiku-3"}, Mirror: &RouteMirror{Target: "haiku-4"}},
+			wantTargets: []RouteTarget{{Upstream: cheap, Model: "haiku-3"}},
+			wantHedge:   true,
+			wantMirror:  true,
+		},
+		{
+			name:    "no allowed target",
+			targets: []string{"*haiku*"},
+			route:   Route{Upstream: premium, Model: "opus", Fallbacks: []RouteTarget{{Upstream: premium, Model: "sonnet"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := compiledPolicy(t, KeyPolicy{Models: tt.models, Targets: tt.targets})
+			route, err := policy.Restrict(tt.route)
+			if tt.wantErr {
+				if _, ok := err.(*policyError); !ok {
+					t.Fatalf("err = %v, want policyError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Restrict: %v", err)
+			}
+			if got := route.Targets(); !reflect.DeepEqual(got, tt.wantTargets) {
+				t.Fatalf("targets = %+v, want %+v", got, tt.wantTargets)
+			}
+			if (route.Hedge != nil) != tt.wantHedge || (route.Mirror != nil) != tt.wantMirror {
+				t.Fatalf("hedge = %v, mirror = %v, want %v, %v", route.Hedge, route.Mirror, tt.wantHedge, tt.wantMirror)
+			}
+		})
+	}
+}
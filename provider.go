@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// ProviderCapabilities 上游能力标志
+type ProviderCapabilities struct {
+	Streaming bool // 支持流式响应
+	Tools     bool // 支持工具调用
+	FileInput bool // 支持file(PDF)内容部分
+	Images    bool // 支持图片输入
+}
+
+// ProviderRequest 一次上游调用所需的上下文
+type ProviderRequest struct {
+	Anthropic MessageCreateParamsBase // 解析后的Anthropic请求
+	RawBody   []byte                  // 客户端发送的原始请求体
+	Header    http.Header             // 客户端请求头
+	Model     string                  // 映射后的上游模型
+	APIKey    string                  // 上游API密钥
+}
+
+// Provider 上游协议适配器，负责请求转换、HTTP调用以及响应和流的转换
+type Provider interface {
+	// Name 返回适配器类型名称
+	Name() string
+	// Capabilities 返回指定模型在该上游的能力标志
+	Capabilities(model string) ProviderCapabilities
+	// ConvertRequest 将Anthropic请求转换为上游请求体
+	ConvertRequest(req *ProviderRequest) (interface{}, error)
+	// Do 发送上游请求
+	Do(ctx context.Context, req *ProviderRequest, upstreamRequest interface{}) (*http.Response, error)
+	// ConvertResponse 将非流式上游响应转换为Anthropic响应，同时返回原始上游响应用于日志记录
+	ConvertResponse(resp *http.Response, req *ProviderRequest) (upstreamResponse interface{}, anthropicResponse interface{}, err error)
+	// ConvertStream 将上游流式响应转换为Anthropic SSE流
+	ConvertStream(resp *http.Response, req *ProviderRequest) io.ReadCloser
+}
+
+// idleConnectionCloser 持有HTTP连接池的适配器实现该接口，上游被替换后关闭旧连接池中的空闲连接
+type idleConnectionCloser interface {
+	CloseIdleConnections()
+}
+
+// UpstreamConfig 上游配置
+type UpstreamConfig struct {
+	Type         string            `json:"type"`
+	BaseURL      string            `json:"base_url"`
+	APIKey       string            `json:"api_key,omitempty"` // 服务端上游密钥，支持 ${ENV} 引用；为空时转发客户端密钥
+	Headers      map[string]string `json:"headers,omitempty"`
+	APIVersion   string            `json:"api_version,omitempty"`    // Azure OpenAI API版本
+	AADTokenFile string            `json:"aad_token_file,omitempty"` // Azure AAD令牌文件，设置后代替api-key认证
+	// ModelOptions 按上游模型名传递的选项(如Ollama的num_ctx)，键"*"对所有模型生效
+	ModelOptions   map[string]map[string]interface{} `json:"model_options,omitempty"`
+	Retry          *RetryConfig                      `json:"retry,omitempty"`
+	KeyPool        *KeyPoolConfig                    `json:"key_pool,omitempty"` // 服务端密钥池，优先于api_key
+	CircuitBreaker *CircuitBreakerConfig             `json:"circuit_breaker,omitempty"`
+	HTTP           *HTTPConfig                       `json:"http,omitempty"` // 连接池与超时配置
+}
+
+// PassthroughConfig Anthropic原生透传配置，Models中的关键词匹配到的请求将直接转发
+type PassthroughConfig struct {
+	UpstreamConfig
+	Models        []string          `json:"models"`
+	ModelMappings map[string]string `json:"model_mappings,omitempty"`
+}
+
+// providerFactory 根据上游配置创建适配器
+type providerFactory func(config UpstreamConfig) (Provider, error)
+
+var providerFactories = make(map[string]providerFactory)
+
+// registerProvider 注册上游类型
+func registerProvider(upstreamType string, factory providerFactory) {
+	providerFactories[upstreamType] = factory
+}
+
+// newProvider 按配置中的类型创建适配器，类型为空时使用OpenAI兼容实现
+func newProvider(config UpstreamConfig) (Provider, error) {
+	upstreamType := config.Type
+	if upstreamType == "" {
+		upstreamType = "openai"
+	}
+	factory, ok := providerFactories[upstreamType]
+	if !ok {
+		return nil, fmt.Errorf("unknown upstream type %q (available: %v)", upstreamType, providerTypes())
+	}
+	return factory(config)
+}
+
+// providerTypes 返回已注册的上游类型
+func providerTypes() []string {
+	var types []string
+	for t := range providerFactories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	registerProvider("anthropic", newAnthropicProvider)
+}
+
+// defaultAnthropicVersion 客户端未提供anthropic-version时使用的版本
+const defaultAnthropicVersion = "2023-06-01"
+
+// anthropicProvider Anthropic原生 /v1/messages 透传上游
+// 请求体、anthropic-version/anthropic-beta请求头以及SSE流均原样转发，只替换映射后的模型名
+type anthropicProvider struct {
+	config UpstreamConfig
+	client *upstreamClient
+}
+
+// newAnthropicProvider 创建Anthropic透传适配器
+func newAnthropicProvider(config UpstreamConfig) (Provider, error) {
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &anthropicProvider{config: config, client: newUpstreamClient(config.HTTP)}, nil
+}
+
+func (p *anthropicProvider) Name() string {
+	return "anthropic"
+}
+
+func (p *anthropicProvider) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
+func (p *anthropicProvider) Capabilities(model string) ProviderCapabilities {
+	return ProviderCapabilities{
+		Streaming: true,
+		Tools:     true,
+		FileInput: true,
+		Images:    true,
+	}
+}
+
+func (p *anthropicProvider) ConvertRequest(req *ProviderRequest) (interface{}, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(req.RawBody, &body); err != nil {
+		return nil, err
+	}
+	if req.Model != req.Anthropic.Model {
+		model, err := json.Marshal(req.Model)
+		if err != nil {
+			return nil, err
+		}
+		body["model"] = model
+		return body, nil
+	}
+	return json.RawMessage(req.RawBody), nil
+}
+
+func (p *anthropicProvider) Do(ctx context.Context, req *ProviderRequest, upstreamRequest interface{}) (*http.Response, error) {
+	requestBody, err := json.Marshal(upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", p.config.BaseURL+"/v1/messages", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("X-Api-Key", req.APIKey)
+	version := req.Header.Get("anthropic-version")
+	if version == "" {
+		version = defaultAnthropicVersion
+	}
+	httpRequest.Header.Set("anthropic-version", version)
+	if beta := req.Header.Get("anthropic-beta"); beta != "" {
+		httpRequest.Header.Set("anthropic-beta", beta)
+	}
+	for key, value := range p.config.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+
+	return p.client.Do(httpRequest)
+}
+
+func (p *anthropicProvider) ConvertResponse(resp *http.Response, req *ProviderRequest) (interface{}, interface{}, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !json.Valid(body) {
+		return nil, nil, errors.New("invalid JSON response from upstream")
+	}
+	return json.RawMessage(body), json.RawMessage(body), nil
+}
+
+func (p *anthropicProvider) ConvertStream(resp *http.Response, req *ProviderRequest) io.ReadCloser {
+	return resp.Body
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerProvider("azure", newAzureProvider)
+}
+
+// defaultAzureAPIVersion 未配置api_version时使用的Azure OpenAI API版本
+const defaultAzureAPIVersion = "2024-10-21"
+
+// azureProvider Azure OpenAI部署上游
+// 请求和响应格式与OpenAI兼容上游相同，映射后的模型名即部署名称
+type azureProvider struct {
+	openAIProvider
+	tokenMu      sync.Mutex
+	token        string
+	tokenModTime time.Time
+}
+
+// newAzureProvider 创建Azure OpenAI适配器
+func newAzureProvider(config UpstreamConfig) (Provider, error) {
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	if config.APIVersion == "" {
+		config.APIVersion = defaultAzureAPIVersion
+	}
+	return &azureProvider{openAIProvider: openAIProvider{config: config, client: newUpstreamClient(config.HTTP)}}, nil
+}
+
+func (p *azureProvider) Name() string {
+	return "azure"
+}
+
+func (p *azureProvider) Do(ctx context.Context, req *ProviderRequest, upstreamRequest interface{}) (*http.Response, error) {
+	requestBody, err := json.Marshal(upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		p.config.BaseURL, url.PathEscape(req.Model), url.QueryEscape(p.config.APIVersion))
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if p.config.AADTokenFile != "" {
+		token, err := p.aadToken()
+		if err != nil {
+			return nil, err
+		}
+		httpRequest.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		httpRequest.Header.Set("api-key", req.APIKey)
+	}
+	for key, value := range p.config.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+
+	return p.client.Do(httpRequest)
+}
+
+// aadToken 读取AAD令牌文件，文件修改后重新加载，以便外部进程轮换令牌
+func (p *azureProvider) aadToken() (string, error) {
+	info, err := os.Stat(p.config.AADTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat AAD token file: %w", err)
+	}
+
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+
+	if p.token != "" && info.ModTime().Equal(p.tokenModTime) {
+		return p.token, nil
+	}
+	data, err := os.ReadFile(p.config.AADTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read AAD token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("AAD token file %s is empty", p.config.AADTokenFile)
+	}
+	p.token = token
+	p.tokenModTime = info.ModTime()
+	return token, nil
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerProvider("gemini", newGeminiProvider)
+}
+
+// defaultGeminiBaseURL Gemini API默认地址
+const defaultGeminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// GeminiRequest Gemini generateContent请求格式
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// GeminiContent Gemini内容
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart Gemini内容部分
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+	InlineData       *GeminiBlob             `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// GeminiBlob 内联二进制数据
+type GeminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// GeminiFileData 通过URI引用的文件
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+// GeminiFunctionCall 函数调用
+type GeminiFunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// GeminiFunctionResponse 函数调用结果
+type GeminiFunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// GeminiTool Gemini工具声明
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+// GeminiFunctionDeclaration 函数声明
+type GeminiFunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// GeminiToolConfig 工具调用配置
+type GeminiToolConfig struct {
+	FunctionCallingConfig GeminiFunctionCallingConfig `json:"functionCallingConfig"`
+}
+
+// GeminiFunctionCallingConfig 函数调用模式
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+// GeminiGenerationConfig 生成参数
+type GeminiGenerationConfig struct {
+	Temperature     *float64              `json:"temperature,omitempty"`
+	TopP            *float64              `json:"topP,omitempty"`
+	TopK            *int                  `json:"topK,omitempty"`
+	MaxOutputTokens int                   `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string              `json:"stopSequences,omitempty"`
+	ThinkingConfig  *GeminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+// GeminiThinkingConfig 思考配置
+type GeminiThinkingConfig struct {
+	ThinkingBudget  int  `json:"thinkingBudget"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+}
+
+// GeminiResponse Gemini generateContent响应格式
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+	ResponseID    string               `json:"responseId,omitempty"`
+	Error         *GeminiError         `json:"error,omitempty"` // 流式响应中途出错时返回
+}
+
+// GeminiError 错误信息
+type GeminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// GeminiCandidate 候选结果
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+}
+
+// GeminiUsageMetadata 用量统计
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// geminiProvider Google Gemini原生 generateContent/streamGenerateContent 上游
+type geminiProvider struct {
+	config UpstreamConfig
+	client *upstreamClient
+}
+
+// newGeminiProvider 创建Gemini适配器
+func newGeminiProvider(config UpstreamConfig) (Provider, error) {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultGeminiBaseURL
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &geminiProvider{config: config, client: newUpstreamClient(config.HTTP)}, nil
+}
+
+func (p *geminiProvider) Name() string {
+	return "gemini"
+}
+
+func (p *geminiProvider) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
+func (p *geminiProvider) Capabilities(model string) ProviderCapabilities {
+	return ProviderCapabilities{
+		Streaming: true,
+		Tools:     true,
+		FileInput: true,
+		Images:    true,
+	}
+}
+
+func (p *geminiProvider) ConvertRequest(req *ProviderRequest) (interface{}, error) {
+	return formatAnthropicToGemini(req.Anthropic)
+}
+
+func (p *geminiProvider) Do(ctx context.Context, req *ProviderRequest, upstreamRequest interface{}) (*http.Response, error) {
+	requestBody, err := json.Marshal(upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/models/%s:generateContent", p.config.BaseURL, url.PathEscape(req.Model))
+	if req.Anthropic.Stream {
+		endpoint = fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", p.config.BaseURL, url.PathEscape(req.Model))
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("x-goog-api-key", req.APIKey)
+	for key, value := range p.config.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+
+	return p.client.Do(httpRequest)
+}
+
+func (p *geminiProvider) ConvertResponse(resp *http.Response, req *ProviderRequest) (interface{}, interface{}, error) {
+	var geminiResponse GeminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResponse); err != nil {
+		return nil, nil, err
+	}
+	return geminiResponse, formatGeminiToAnthropic(geminiResponse, req.Model), nil
+}
+
+func (p *geminiProvider) ConvertStream(resp *http.Response, req *ProviderRequest) io.ReadCloser {
+	return streamGeminiToAnthropic(resp.Body, req.Model)
+}
+
+// formatAnthropicToGemini 将Anthropic请求转换为Gemini generateContent请求
+func formatAnthropicToGemini(body MessageCreateParamsBase) (GeminiRequest, error) {
+	var request GeminiRequest
+
+	// tool_result只携带tool_use_id，Gemini的functionResponse需要函数名
+	toolNames := make(map[string]string)
+
+	for _, message := range body.Messages {
+		role := "user"
+		if message.Role == "assistant" {
+			role = "model"
+		}
+
+		var parts []GeminiPart
+		signature := ""
+		for _, block := range contentBlocks(message.Content) {
+			switch block.Type {
+			case "text":
+				if block.Text != "" {
+					parts = append(parts, GeminiPart{Text: block.Text})
+				}
+			case "thinking":
+				// 思考内容由Gemini自身保存，只需回传签名
+				if block.Signature != "" {
+					signature = block.Signature
+				}
+			case "redacted_thinking":
+				if block.Data != "" {
+					signature = block.Data
+				}
+			case "image", "document":
+				if part, ok := geminiMediaPart(block); ok {
+					parts = append(parts, part)
+				}
+			case "tool_use":
+				toolNames[block.ID] = block.Name
+				parts = append(parts, GeminiPart{
+					FunctionCall: &GeminiFunctionCall{
+						ID:   block.ID,
+						Name: block.Name,
+						Args: block.Input,
+					},
+				})
+			case "tool_result":
+				response := map[string]interface{}{}
+				text := contentText(block.Content)
+				if block.IsError {
+					response["error"] = text
+				} else {
+					response["content"] = text
+				}
+				parts = append(parts, GeminiPart{
+					FunctionResponse: &GeminiFunctionResponse{
+						ID:       block.ToolUseID,
+						Name:     toolNames[block.ToolUseID],
+						Response: response,
+					},
+				})
+				// 工具结果中的图片作为额外的内容部分
+				for _, inner := range contentBlocks(block.Content) {
+					if inner.Type == "image" {
+						if part, ok := geminiMediaPart(inner); ok {
+							parts = append(parts, part)
+						}
+					}
+				}
+			}
+		}
+
+		// 思考签名附加到第一个函数调用上，没有函数调用时附加到第一个部分
+		if signature != "" && len(parts) > 0 {
+			target := 0
+			for i, part := range parts {
+				if part.FunctionCall != nil {
+					target = i
+					break
+				}
+			}
+			parts[target].ThoughtSignature = signature
+		}
+
+		if len(parts) > 0 {
+			request.Contents = append(request.Contents, GeminiContent{Role: role, Parts: parts})
+		}
+	}
+
+	if systemText := systemPromptText(body.System); systemText != "" {
+		request.SystemInstruction = &GeminiContent{Parts: []GeminiPart{{Text: systemText}}}
+	}
+
+	if len(body.Tools) > 0 {
+		var declarations []GeminiFunctionDeclaration
+		for _, tool := range body.Tools {
+			declaration := GeminiFunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+			}
+			if tool.InputSchema != nil {
+				if schema, ok := sanitizeGeminiSchema(tool.InputSchema).(map[string]interface{}); ok {
+					// 没有属性的object参数会被Gemini拒绝
+					if props, ok := schema["properties"].(map[string]interface{}); !ok || len(props) > 0 {
+						declaration.Parameters = schema
+					}
+				}
+			}
+			declarations = append(declarations, declaration)
+		}
+		request.Tools = []GeminiTool{{FunctionDeclarations: declarations}}
+
+		if body.ToolChoice != nil {
+			config := GeminiFunctionCallingConfig{Mode: "AUTO"}
+			switch body.ToolChoice.Type {
+			case "any":
+				config.Mode = "ANY"
+			case "none":
+				config.Mode = "NONE"
+			case "tool":
+				config.Mode = "ANY"
+				config.AllowedFunctionNames = []string{body.ToolChoice.Name}
+			}
+			request.ToolConfig = &GeminiToolConfig{FunctionCallingConfig: config}
+		}
+	}
+
+	generationConfig := &GeminiGenerationConfig{
+		Temperature:     body.Temperature,
+		TopP:            body.TopP,
+		TopK:            body.TopK,
+		MaxOutputTokens: body.MaxTokens,
+		StopSequences:   body.StopSequences,
+	}
+	if body.Thinking != nil && body.Thinking.Type == "enabled" {
+		generationConfig.ThinkingConfig = &GeminiThinkingConfig{
+			ThinkingBudget:  body.Thinking.BudgetTokens,
+			IncludeThoughts: true,
+		}
+	}
+	request.GenerationConfig = generationConfig
+
+	return request, nil
+}
+
+// geminiMediaPart 将image/document块转换为Gemini的inlineData或fileData部分
+func geminiMediaPart(block ContentPart) (GeminiPart, bool) {
+	if block.Source == nil {
+		return GeminiPart{}, false
+	}
+	switch block.Source.Type {
+	case "base64":
+		return GeminiPart{InlineData: &GeminiBlob{MimeType: block.Source.MediaType, Data: block.Source.Data}}, true
+	case "url":
+		return GeminiPart{FileData: &GeminiFileData{MimeType: block.Source.MediaType, FileURI: block.Source.URL}}, true
+	case "text", "content":
+		return GeminiPart{Text: convertDocumentBlock(block, false).Text}, true
+	}
+	return GeminiPart{}, false
+}
+
+// geminiSchemaKeys Gemini函数参数支持的OpenAPI Schema字段
+var geminiSchemaKeys = map[string]bool{
+	"type":        true,
+	"format":      true,
+	"title":       true,
+	"description": true,
+	"nullable":    true,
+	"enum":        true,
+	"maxItems":    true,
+	"minItems":    true,
+	"properties":  true,
+	"required":    true,
+	"items":       true,
+	"anyOf":       true,
+	"minimum":     true,
+	"maximum":     true,
+	"minLength":   true,
+	"maxLength":   true,
+	"pattern":     true,
+}
+
+// sanitizeGeminiSchema 将JSON Schema清理为Gemini接受的子集
+// 去除不支持的字段，把类型数组转换为nullable，oneOf视为anyOf，const转换为enum
+func sanitizeGeminiSchema(schema interface{}) interface{} {
+	source, ok := schema.(map[string]interface{})
+	if !ok {
+		return schema
+	}
+
+	result := make(map[string]interface{})
+	for key, value := range source {
+		switch key {
+		case "type":
+			if types, ok := value.([]interface{}); ok {
+				for _, t := range types {
+					if t == "null" {
+						result["nullable"] = true
+					} else if _, set := result["type"]; !set {
+						result["type"] = t
+					}
+				}
+				continue
+			}
+			result["type"] = value
+		case "properties":
+			if props, ok := value.(map[string]interface{}); ok {
+				cleaned := make(map[string]interface{})
+				for name, prop := range props {
+					cleaned[name] = sanitizeGeminiSchema(prop)
+				}
+				result["properties"] = cleaned
+			}
+		case "items":
+			result["items"] = sanitizeGeminiSchema(value)
+		case "anyOf", "oneOf":
+			if variants, ok := value.([]interface{}); ok {
+				var cleaned []interface{}
+				for _, variant := range variants {
+					if m, ok := variant.(map[string]interface{}); ok && m["type"] == "null" {
+						result["nullable"] = true
+						continue
+					}
+					cleaned = append(cleaned, sanitizeGeminiSchema(variant))
+				}
+				if len(cleaned) == 1 {
+					if single, ok := cleaned[0].(map[string]interface{}); ok {
+						for k, v := range single {
+							if _, exists := result[k]; !exists {
+								result[k] = v
+							}
+						}
+					}
+				} else if len(cleaned) > 1 {
+					result["anyOf"] = cleaned
+				}
+			}
+		case "allOf":
+			// 只保留第一个子模式
+			if variants, ok := value.([]interface{}); ok && len(variants) > 0 {
+				if first, ok := sanitizeGeminiSchema(variants[0]).(map[string]interface{}); ok {
+					for k, v := range first {
+						if _, exists := result[k]; !exists {
+							result[k] = v
+						}
+					}
+				}
+			}
+		case "const":
+			result["enum"] = []interface{}{fmt.Sprint(value)}
+		case "enum":
+			if values, ok := value.([]interface{}); ok {
+				var enums []interface{}
+				for _, v := range values {
+					if v != nil {
+						enums = append(enums, fmt.Sprint(v))
+					}
+				}
+				result["enum"] = enums
+			}
+		case "format":
+			result["format"] = value
+		default:
+			if geminiSchemaKeys[key] {
+				result[key] = value
+			}
+		}
+	}
+
+	if _, hasEnum := result["enum"]; hasEnum {
+		result["type"] = "string"
+	}
+
+	// 字符串只支持enum和date-time格式
+	if format, ok := result["format"].(string); ok && result["type"] == "string" && format != "enum" && format != "date-time" {
+		delete(result, "format")
+	}
+
+	// required只能引用已声明的属性
+	if required, ok := result["required"].([]interface{}); ok {
+		props, _ := result["properties"].(map[string]interface{})
+		var filtered []interface{}
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := props[key]; exists {
+					filtered = append(filtered, name)
+				}
+			}
+		}
+		if len(filtered) > 0 {
+			result["required"] = filtered
+		} else {
+			delete(result, "required")
+		}
+	}
+
+	return result
+}
+
+// geminiStopReason 将Gemini的finishReason转换为Anthropic的stop_reason
+func geminiStopReason(finishReason string, hasToolUse bool) string {
+	if hasToolUse {
+		return "tool_use"
+	}
+	switch finishReason {
+	case "MAX_TOKENS":
+		return "max_tokens"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "refusal"
+	}
+	return "end_turn"
+}
+
+// formatGeminiToAnthropic 将Gemini响应转换为Anthropic响应
+func formatGeminiToAnthropic(response GeminiResponse, model string) AnthropicResponse {
+	var content []AnthropicContent
+	hasToolUse := false
+	finishReason := ""
+	signature := ""
+
+	if len(response.Candidates) > 0 {
+		candidate := response.Candidates[0]
+		finishReason = candidate.FinishReason
+		for _, part := range candidate.Content.Parts {
+			if part.ThoughtSignature != "" && signature == "" {
+				signature = part.ThoughtSignature
+			}
+			switch {
+			case part.Thought:
+				if len(content) > 0 && content[len(content)-1].Type == "thinking" {
+					content[len(content)-1].Thinking += part.Text
+				} else {
+					content = append(content, AnthropicContent{Type: "thinking", Thinking: part.Text})
+				}
+			case part.FunctionCall != nil:
+				hasToolUse = true
+				id := part.FunctionCall.ID
+				if id == "" {
+					id = generateToolUseID()
+				}
+				input := part.FunctionCall.Args
+				if input == nil {
+					input = map[string]interface{}{}
+				}
+				content = append(content, AnthropicContent{
+					Type:  "tool_use",
+					ID:    id,
+					Name:  part.FunctionCall.Name,
+					Input: input,
+				})
+			case part.Text != "":
+				if len(content) > 0 && content[len(content)-1].Type == "text" {
+					content[len(content)-1].Text += part.Text
+				} else {
+					content = append(content, AnthropicContent{Type: "text", Text: part.Text})
+				}
+			}
+		}
+	}
+
+	// 思考签名保存在thinking块中，以便下一轮原样回传
+	if signature != "" {
+		attached := false
+		for i := range content {
+			if content[i].Type == "thinking" {
+				content[i].Signature = signature
+				attached = true
+				break
+			}
+		}
+		if !attached {
+			content = append([]AnthropicContent{{Type: "redacted_thinking", Data: signature}}, content...)
+		}
+	}
+
+	var usage AnthropicUsage
+	if response.UsageMetadata != nil {
+		usage = AnthropicUsage{
+			InputTokens:  response.UsageMetadata.PromptTokenCount,
+			OutputTokens: response.UsageMetadata.CandidatesTokenCount + response.UsageMetadata.ThoughtsTokenCount,
+		}
+	}
+
+	return AnthropicResponse{
+		ID:           fmt.Sprintf("msg_%d", time.Now().UnixMilli()),
+		Type:         "message",
+		Role:         "assistant",
+		Content:      content,
+		StopReason:   geminiStopReason(finishReason, hasToolUse),
+		StopSequence: nil,
+		Model:        model,
+		Usage:        usage,
+	}
+}
+
+// streamGeminiToAnthropic 将Gemini SSE流(alt=sse)转换为Anthropic流式响应
+func streamGeminiToAnthropic(geminiStream io.ReadCloser, model string) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer pw.Close()
+		defer geminiStream.Close()
+
+		writer := newAnthropicStreamWriter(pw, model)
+		finishReason := ""
+		pendingSignature := ""
+		streamError := ""
+
+		err := readSSEData(geminiStream, func(event, data string) {
+			if streamError != "" {
+				return
+			}
+			var chunk GeminiResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return
+			}
+			if chunk.Error != nil {
+				streamError = fmt.Sprintf("Gemini error %d %s: %s", chunk.Error.Code, chunk.Error.Status, chunk.Error.Message)
+				return
+			}
+			if chunk.UsageMetadata != nil {
+				writer.InputTokens = chunk.UsageMetadata.PromptTokenCount
+				writer.OutputTokens = chunk.UsageMetadata.CandidatesTokenCount + chunk.UsageMetadata.ThoughtsTokenCount
+			}
+			if len(chunk.Candidates) == 0 {
+				return
+			}
+			candidate := chunk.Candidates[0]
+			if candidate.FinishReason != "" {
+				finishReason = candidate.FinishReason
+			}
+
+			for _, part := range candidate.Content.Parts {
+				switch {
+				case part.Thought:
+					writer.Thinking(part.Text)
+					if part.ThoughtSignature != "" {
+						writer.Signature(part.ThoughtSignature)
+					}
+					continue
+				case part.ThoughtSignature != "" && pendingSignature == "":
+					// 签名出现在非思考部分上时，在该部分之前以独立的思考块发出
+					pendingSignature = part.ThoughtSignature
+					if writer.blockType == "thinking" {
+						writer.Signature(pendingSignature)
+					} else {
+						writer.CloseBlock()
+						writer.startBlock("redacted_thinking", map[string]interface{}{"type": "redacted_thinking", "data": pendingSignature})
+						writer.CloseBlock()
+					}
+				}
+
+				if part.FunctionCall != nil {
+					id := part.FunctionCall.ID
+					if id == "" {
+						id = generateToolUseID()
+					}
+					args := part.FunctionCall.Args
+					if args == nil {
+						args = map[string]interface{}{}
+					}
+					argsBytes, _ := json.Marshal(args)
+					writer.StartToolUse(id, part.FunctionCall.Name)
+					writer.ToolInput(string(argsBytes))
+					writer.CloseBlock()
+				} else if part.Text != "" {
+					writer.Text(part.Text)
+				}
+			}
+		})
+
+		switch {
+		case streamError != "":
+			writer.Error(streamError)
+		case err != nil:
+			writer.Error("Upstream stream read failed: " + err.Error())
+		case finishReason == "":
+			// 每个流都以带finishReason的块结束，没有时说明连接被提前关闭
+			writer.Error("Upstream stream ended before the response was complete")
+		default:
+			writer.Finish(geminiStopReason(finishReason, writer.hasToolUse))
+		}
+	}()
+
+	return pr
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseAnthropicRequest 解析JSON格式的Anthropic请求
+func parseAnthropicRequest(t *testing.T, body string) MessageCreateParamsBase {
+	t.Helper()
+	var request MessageCreateParamsBase
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestFormatAnthropicToGemini(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		check func(t *testing.T, request GeminiRequest)
+	}{
+		{
+			name: "roles and system",
+			body: `{"model": "m", "max_tokens": 100, "system": [{"type": "text", "text": "be brief"}],
+				"messages": [{"role": "user", "content": "hi"}, {"role": "assistant", "content": "hello"}]}`,
+			check: func(t *testing.T, request GeminiRequest) {
+				if request.SystemInstruction == nil || request.SystemInstruction.Parts[0].Text != "be brief" {
+					t.Fatalf("system = %+v", request.SystemInstruction)
+				}
+				if len(request.Contents) != 2 || request.Contents[0].Role != "user" || request.Contents[1].Role != "model" {
+					t.Fatalf("contents = %+v", request.Contents)
+				}
+				if request.GenerationConfig.MaxOutputTokens != 100 || request.GenerationConfig.ThinkingConfig != nil {
+					t.Fatalf("generation config = %+v", request.GenerationConfig)
+				}
+			},
+		},
+		{
+			name: "tool round trip carries thought signature on the function call",
+			body: `{"model": "m", "thinking": {"type": "enabled", "budget_tokens": 1024}, "messages": [
+				{"role": "user", "content": "weather?"},
+				{"role": "assistant", "content": [
+					{"type": "thinking", "thinking": "look it up", "signature": "sig-1"},
+					{"type": "text", "text": "checking"},
+					{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "call_1", "content": "sunny"}]}]}`,
+			check: func(t *testing.T, request GeminiRequest) {
+				model := request.Contents[1].Parts
+				if len(model) != 2 || model[0].ThoughtSignature != "" || model[1].FunctionCall == nil || model[1].ThoughtSignature != "sig-1" {
+					t.Fatalf("model parts = %+v", model)
+				}
+				response := request.Contents[2].Parts[0].FunctionResponse
+				if response == nil || response.Name != "get_weather" || response.ID != "call_1" || response.Response["content"] != "sunny" {
+					t.Fatalf("function response = %+v", response)
+				}
+				if config := request.GenerationConfig.ThinkingConfig; config == nil || config.ThinkingBudget != 1024 || !config.IncludeThoughts {
+					t.Fatalf("thinking config = %+v", config)
+				}
+			},
+		},
+		{
+			name: "redacted thinking signature without function call goes on first part",
+			body: `{"model": "m", "messages": [{"role": "assistant", "content": [
+				{"type": "redacted_thinking", "data": "sig-2"}, {"type": "text", "text": "answer"}]}]}`,
+			check: func(t *testing.T, request GeminiRequest) {
+				if parts := request.Contents[0].Parts; len(parts) != 1 || parts[0].ThoughtSignature != "sig-2" || parts[0].Text != "answer" {
+					t.Fatalf("parts = %+v", parts)
+				}
+			},
+		},
+		{
+			name: "tools and forced tool choice",
+			body: `{"model": "m", "messages": [{"role": "user", "content": "hi"}],
+				"tools": [{"name": "noop", "input_schema": {"type": "object", "properties": {}}},
+					{"name": "search", "input_schema": {"type": "object", "properties": {"q": {"type": "string"}}}}],
+				"tool_choice": {"type": "tool", "name": "search"}}`,
+			check: func(t *testing.T, request GeminiRequest) {
+				declarations := request.Tools[0].FunctionDeclarations
+				if declarations[0].Parameters != nil || declarations[1].Parameters == nil {
+					t.Fatalf("declarations = %+v", declarations)
+				}
+				config := request.ToolConfig.FunctionCallingConfig
+				if config.Mode != "ANY" || !reflect.DeepEqual(config.AllowedFunctionNames, []string{"search"}) {
+					t.Fatalf("tool config = %+v", config)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := formatAnthropicToGemini(parseAnthropicRequest(t, tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, request)
+		})
+	}
+}
+
+func TestSanitizeGeminiSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"drops unsupported keys", `{"type": "object", "$schema": "x", "additionalProperties": false, "properties": {"a": {"type": "string", "default": "x"}}}`,
+			`{"type": "object", "properties": {"a": {"type": "string"}}}`},
+		{"type array becomes nullable", `{"type": ["string", "null"]}`, `{"type": "string", "nullable": true}`},
+		{"oneOf with null collapses", `{"oneOf": [{"type": "integer"}, {"type": "null"}]}`, `{"type": "integer", "nullable": true}`},
+		{"anyOf keeps variants", `{"anyOf": [{"type": "integer"}, {"type": "string"}]}`, `{"anyOf": [{"type": "integer"}, {"type": "string"}]}`},
+		{"const becomes string enum", `{"const": 3}`, `{"type": "string", "enum": ["3"]}`},
+		{"unsupported string format removed", `{"type": "string", "format": "uri"}`, `{"type": "string"}`},
+		{"date-time format kept", `{"type": "string", "format": "date-time"}`, `{"type": "string", "format": "date-time"}`},
+		{"required filtered to declared properties", `{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a", "b"]}`,
+			`{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}`},
+		{"nested items", `{"type": "array", "items": {"type": "object", "properties": {"x": {"type": "number", "exclusiveMinimum": 0}}}}`,
+			`{"type": "array", "items": {"type": "object", "properties": {"x": {"type": "number"}}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema, want interface{}
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(sanitizeGeminiSchema(schema))
+			var decoded interface{}
+			json.Unmarshal(got, &decoded)
+			if !reflect.DeepEqual(decoded, want) {
+				t.Fatalf("schema = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// geminiTestServer 返回固定响应的Gemini服务，并记录收到的请求
+func geminiTestServer(t *testing.T, body string) (*httptest.Server, *http.Request, *GeminiRequest) {
+	t.Helper()
+	var received http.Request
+	var request GeminiRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = *r
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if strings.Contains(r.URL.Path, ":streamGenerateContent") {
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &received, &request
+}
+
+// geminiSSE 把Gemini响应块编码为SSE流
+func geminiSSE(chunks ...string) string {
+	var b strings.Builder
+	for _, chunk := range chunks {
+		b.WriteString("data: " + chunk + "\r\n\r\n")
+	}
+	return b.String()
+}
+
+func TestGeminiProviderStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		want      []string
+		wantStop  string
+		wantError string
+	}{
+		{
+			name: "thinking, signature and function call",
+			body: geminiSSE(
+				`{"candidates": [{"content": {"role": "model", "parts": [{"text": "pondering", "thought": true}]}}]}`,
+				`{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}, "thoughtSignature": "sig-1"}]}}]}`,
+				`{"candidates": [{"content": {"role": "model", "parts": [{"text": ""}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5, "thoughtsTokenCount": 2}}`,
+			),
+			want: []string{
+				"message_start",
+				"content_block_start:thinking", "content_block_delta:thinking_delta", "content_block_delta:signature_delta", "content_block_stop",
+				"content_block_start:tool_use", "content_block_delta:input_json_delta", "content_block_stop",
+				"message_delta", "message_stop",
+			},
+			wantStop: "tool_use",
+		},
+		{
+			name: "signature on text part becomes redacted thinking",
+			body: geminiSSE(
+				`{"candidates": [{"content": {"role": "model", "parts": [{"text": "hi", "thoughtSignature": "sig-2"}]}, "finishReason": "MAX_TOKENS"}]}`,
+			),
+			want: []string{
+				"message_start",
+				"content_block_start:redacted_thinking", "content_block_stop",
+				"content_block_start:text", "content_block_delta:text_delta", "content_block_stop",
+				"message_delta", "message_stop",
+			},
+			wantStop: "max_tokens",
+		},
+		{
+			name: "error chunk",
+			body: geminiSSE(
+				`{"candidates": [{"content": {"role": "model", "parts": [{"text": "partial"}]}}]}`,
+				`{"error": {"code": 503, "message": "model overloaded", "status": "UNAVAILABLE"}}`,
+			),
+			want:      []string{"message_start", "content_block_start:text", "content_block_delta:text_delta", "content_block_stop", "error"},
+			wantError: "model overloaded",
+		},
+		{
+			name:      "stream closed before finish",
+			body:      geminiSSE(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "partial"}]}}]}`),
+			want:      []string{"message_start", "content_block_start:text", "content_block_delta:text_delta", "content_block_stop", "error"},
+			wantError: "ended before",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, received, request := geminiTestServer(t, tt.body)
+			provider, err := newGeminiProvider(UpstreamConfig{BaseURL: server.URL + "/v1beta/"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &ProviderRequest{
+				Anthropic: parseAnthropicRequest(t, `{"model": "claude", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`),
+				Model:     "gemini-2.5-pro",
+				APIKey:    "test-key",
+			}
+			upstreamRequest, err := provider.ConvertRequest(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := provider.Do(context.Background(), req, upstreamRequest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := readAnthropicEvents(t, provider.ConvertStream(resp, req))
+
+			if received.URL.Path != "/v1beta/models/gemini-2.5-pro:streamGenerateContent" || received.URL.Query().Get("alt") != "sse" {
+				t.Fatalf("request URL = %s", received.URL)
+			}
+			if received.Header.Get("x-goog-api-key") != "test-key" || len(request.Contents) != 1 {
+				t.Fatalf("request header = %v, body = %+v", received.Header, request)
+			}
+			if got := eventTypes(events); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			last := events[len(events)-1]
+			if tt.wantError != "" {
+				message, _ := last.Data["error"].(map[string]interface{})["message"].(string)
+				if !strings.Contains(message, tt.wantError) {
+					t.Fatalf("error message = %q, want %q", message, tt.wantError)
+				}
+				return
+			}
+			delta := events[len(events)-2].Data["delta"].(map[string]interface{})
+			if delta["stop_reason"] != tt.wantStop {
+				t.Fatalf("stop_reason = %v, want %s", delta["stop_reason"], tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestGeminiProviderResponse(t *testing.T) {
+	server, received, _ := geminiTestServer(t, `{"candidates": [{"content": {"role": "model", "parts": [
+		{"text": "thinking it over", "thought": true},
+		{"text": "Paris", "thoughtSignature": "sig-3"}]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 7, "candidatesTokenCount": 3, "thoughtsTokenCount": 4}}`)
+	provider, err := newGeminiProvider(UpstreamConfig{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &ProviderRequest{
+		Anthropic: parseAnthropicRequest(t, `{"model": "claude", "messages": [{"role": "user", "content": "capital of France?"}]}`),
+		Model:     "gemini-2.5-flash",
+	}
+	upstreamRequest, _ := provider.ConvertRequest(req)
+	resp, err := provider.Do(context.Background(), req, upstreamRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, converted, err := provider.ConvertResponse(resp, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.URL.Path != "/models/gemini-2.5-flash:generateContent" {
+		t.Fatalf("request path = %s", received.URL.Path)
+	}
+	response := converted.(AnthropicResponse)
+	if len(response.Content) != 2 || response.Content[0].Type != "thinking" || response.Content[0].Signature != "sig-3" || response.Content[1].Text != "Paris" {
+		t.Fatalf("content = %+v", response.Content)
+	}
+	if response.StopReason != "end_turn" || response.Usage != (AnthropicUsage{InputTokens: 7, OutputTokens: 7}) {
+		t.Fatalf("stop_reason = %s, usage = %+v", response.StopReason, response.Usage)
+	}
+}
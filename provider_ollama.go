@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerProvider("ollama", newOllamaProvider)
+}
+
+// defaultOllamaBaseURL Ollama本地服务默认地址
+const defaultOllamaBaseURL = "http://localhost:11434"
+
+// OllamaChatRequest Ollama /api/chat 请求格式
+type OllamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []OllamaMessage        `json:"messages"`
+	Tools    []OpenAITool           `json:"tools,omitempty"`
+	Stream   bool                   `json:"stream"`
+	Think    *bool                  `json:"think,omitempty"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+// OllamaMessage Ollama消息
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+// OllamaToolCall Ollama工具调用，参数为JSON对象而非字符串
+type OllamaToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+// OllamaChatResponse Ollama /api/chat 响应，流式模式下每行一个
+type OllamaChatResponse struct {
+	Model           string        `json:"model"`
+	CreatedAt       string        `json:"created_at"`
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// ollamaProvider Ollama原生 /api/chat 上游
+type ollamaProvider struct {
+	config UpstreamConfig
+	client *upstreamClient
+}
+
+// newOllamaProvider 创建Ollama适配器
+func newOllamaProvider(config UpstreamConfig) (Provider, error) {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultOllamaBaseURL
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &ollamaProvider{config: config, client: newUpstreamClient(config.HTTP)}, nil
+}
+
+func (p *ollamaProvider) Name() string {
+	return "ollama"
+}
+
+// Keyless Ollama不需要API密钥
+func (p *ollamaProvider) Keyless() bool {
+	return true
+}
+
+func (p *ollamaProvider) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
+func (p *ollamaProvider) Capabilities(model string) ProviderCapabilities {
+	return ProviderCapabilities{
+		Streaming: true,
+		Tools:     true,
+		FileInput: false,
+		Images:    true,
+	}
+}
+
+func (p *ollamaProvider) ConvertRequest(req *ProviderRequest) (interface{}, error) {
+	request, err := formatAnthropicToOllama(req.Anthropic, req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	// 配置中的模型选项(如num_ctx)作为基础，请求中的采样参数覆盖同名选项
+	options := make(map[string]interface{})
+	modelOptions, ok := p.config.ModelOptions[req.Model]
+	if !ok {
+		modelOptions = p.config.ModelOptions["*"]
+	}
+	for key, value := range modelOptions {
+		options[key] = value
+	}
+	for key, value := range request.Options {
+		options[key] = value
+	}
+	request.Options = nil
+	if len(options) > 0 {
+		request.Options = options
+	}
+	return request, nil
+}
+
+func (p *ollamaProvider) Do(ctx context.Context, req *ProviderRequest, upstreamRequest interface{}) (*http.Response, error) {
+	requestBody, err := json.Marshal(upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", p.config.BaseURL+"/api/chat", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	for key, value := range p.config.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+
+	return p.client.Do(httpRequest)
+}
+
+func (p *ollamaProvider) ConvertResponse(resp *http.Response, req *ProviderRequest) (interface{}, interface{}, error) {
+	var response OllamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil, err
+	}
+	if response.Error != "" {
+		return nil, nil, fmt.Errorf("ollama error: %s", response.Error)
+	}
+	return response, formatOllamaToAnthropic(response, req.Model), nil
+}
+
+func (p *ollamaProvider) ConvertStream(resp *http.Response, req *ProviderRequest) io.ReadCloser {
+	return streamOllamaToAnthropic(resp.Body, req.Model)
+}
+
+// formatAnthropicToOllama 将Anthropic请求转换为Ollama /api/chat 请求
+func formatAnthropicToOllama(body MessageCreateParamsBase, model string) (OllamaChatRequest, error) {
+	request := OllamaChatRequest{
+		Model:  model,
+		Stream: body.Stream,
+	}
+
+	if systemText := systemPromptText(body.System); systemText != "" {
+		request.Messages = append(request.Messages, OllamaMessage{Role: "system", Content: systemText})
+	}
+
+	toolNames := make(map[string]string)
+	for _, message := range body.Messages {
+		var toolMessages []OllamaMessage
+		current := OllamaMessage{Role: message.Role}
+		var texts []string
+
+		for _, block := range contentBlocks(message.Content) {
+			switch block.Type {
+			case "text":
+				if block.Text != "" {
+					texts = append(texts, block.Text)
+				}
+			case "thinking":
+				current.Thinking += block.Thinking
+			case "image":
+				if block.Source != nil && block.Source.Type == "base64" {
+					current.Images = append(current.Images, block.Source.Data)
+				}
+			case "document":
+				texts = append(texts, convertDocumentBlock(block, false).Text)
+			case "tool_use":
+				toolNames[block.ID] = block.Name
+				var call OllamaToolCall
+				call.Function.Name = block.Name
+				call.Function.Arguments = block.Input
+				if call.Function.Arguments == nil {
+					call.Function.Arguments = map[string]interface{}{}
+				}
+				current.ToolCalls = append(current.ToolCalls, call)
+			case "tool_result":
+				toolMessage := OllamaMessage{
+					Role:     "tool",
+					Content:  contentText(block.Content),
+					ToolName: toolNames[block.ToolUseID],
+				}
+				for _, inner := range contentBlocks(block.Content) {
+					if inner.Type == "image" && inner.Source != nil && inner.Source.Type == "base64" {
+						toolMessage.Images = append(toolMessage.Images, inner.Source.Data)
+					}
+				}
+				toolMessages = append(toolMessages, toolMessage)
+			}
+		}
+
+		// 工具结果需要紧跟在对应的助手消息之后
+		request.Messages = append(request.Messages, toolMessages...)
+		current.Content = strings.Join(texts, "\n")
+		if current.Content != "" || len(current.Images) > 0 || len(current.ToolCalls) > 0 {
+			request.Messages = append(request.Messages, current)
+		}
+	}
+
+	for _, tool := range body.Tools {
+		request.Tools = append(request.Tools, OpenAITool{
+			Type: "function",
+			Function: OpenAIFunctionTool{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
+	options := make(map[string]interface{})
+	if body.Temperature != nil {
+		options["temperature"] = *body.Temperature
+	}
+	if body.TopP != nil {
+		options["top_p"] = *body.TopP
+	}
+	if body.TopK != nil {
+		options["top_k"] = *body.TopK
+	}
+	if body.MaxTokens > 0 {
+		options["num_predict"] = body.MaxTokens
+	}
+	if len(body.StopSequences) > 0 {
+		options["stop"] = body.StopSequences
+	}
+	request.Options = options
+
+	if body.Thinking != nil {
+		think := body.Thinking.Type == "enabled"
+		request.Think = &think
+	}
+
+	return request, nil
+}
+
+// ollamaStopReason 将done_reason转换为Anthropic的stop_reason
+func ollamaStopReason(doneReason string, hasToolUse bool) string {
+	if hasToolUse {
+		return "tool_use"
+	}
+	if doneReason == "length" {
+		return "max_tokens"
+	}
+	return "end_turn"
+}
+
+// formatOllamaToAnthropic 将Ollama响应转换为Anthropic响应
+func formatOllamaToAnthropic(response OllamaChatResponse, model string) AnthropicResponse {
+	var content []AnthropicContent
+	if response.Message.Thinking != "" {
+		content = append(content, AnthropicContent{Type: "thinking", Thinking: response.Message.Thinking})
+	}
+	if response.Message.Content != "" {
+		content = append(content, AnthropicContent{Type: "text", Text: response.Message.Content})
+	}
+	for _, call := range response.Message.ToolCalls {
+		input := call.Function.Arguments
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		content = append(content, AnthropicContent{
+			Type:  "tool_use",
+			ID:    generateToolUseID(),
+			Name:  call.Function.Name,
+			Input: input,
+		})
+	}
+
+	return AnthropicResponse{
+		ID:           fmt.Sprintf("msg_%d", time.Now().UnixMilli()),
+		Type:         "message",
+		Role:         "assistant",
+		Content:      content,
+		StopReason:   ollamaStopReason(response.DoneReason, len(response.Message.ToolCalls) > 0),
+		StopSequence: nil,
+		Model:        model,
+		Usage: AnthropicUsage{
+			InputTokens:  response.PromptEvalCount,
+			OutputTokens: response.EvalCount,
+		},
+	}
+}
+
+// streamOllamaToAnthropic 将Ollama NDJSON流转换为Anthropic流式响应
+func streamOllamaToAnthropic(ollamaStream io.ReadCloser, model string) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer pw.Close()
+		defer ollamaStream.Close()
+
+		writer := newAnthropicStreamWriter(pw, model)
+		doneReason := ""
+		done := false
+
+		scanner := bufio.NewScanner(ollamaStream)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var chunk OllamaChatResponse
+			if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+				continue
+			}
+			if chunk.Error != "" {
+				writer.Error("Ollama error: " + chunk.Error)
+				return
+			}
+
+			writer.Thinking(chunk.Message.Thinking)
+			writer.Text(chunk.Message.Content)
+			for _, call := range chunk.Message.ToolCalls {
+				args := call.Function.Arguments
+				if args == nil {
+					args = map[string]interface{}{}
+				}
+				argsBytes, _ := json.Marshal(args)
+				writer.StartToolUse(generateToolUseID(), call.Function.Name)
+				writer.ToolInput(string(argsBytes))
+				writer.CloseBlock()
+			}
+
+			if chunk.Done {
+				done = true
+				doneReason = chunk.DoneReason
+				writer.InputTokens = chunk.PromptEvalCount
+				writer.OutputTokens = chunk.EvalCount
+				break
+			}
+		}
+
+		switch {
+		case scanner.Err() != nil:
+			writer.Error("Upstream stream read failed: " + scanner.Err().Error())
+		case !done:
+			writer.Error("Upstream stream ended before the response was complete")
+		default:
+			writer.Finish(ollamaStopReason(doneReason, writer.hasToolUse))
+		}
+	}()
+
+	return pr
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamOllamaToAnthropic(t *testing.T) {
+	textChunk := `{"message": {"role": "assistant", "content": "Hi"}, "done": false}` + "\n"
+	textTypes := []string{"message_start", "content_block_start:text", "content_block_delta:text_delta"}
+	tests := []struct {
+		name      string
+		stream    io.Reader
+		want      []string
+		wantStop  string
+		wantError string
+	}{
+		{
+			name:     "done",
+			stream:   strings.NewReader(textChunk + `{"message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "stop", "prompt_eval_count": 4, "eval_count": 2}` + "\n"),
+			want:     append(textTypes, "content_block_stop", "message_delta", "message_stop"),
+			wantStop: "end_turn",
+		},
+		{
+			name:     "length limit",
+			stream:   strings.NewReader(textChunk + `{"done": true, "done_reason": "length"}` + "\n"),
+			want:     append(textTypes, "content_block_stop", "message_delta", "message_stop"),
+			wantStop: "max_tokens",
+		},
+		{
+			name: "tool call",
+			stream: strings.NewReader(`{"message": {"role": "assistant", "tool_calls": [{"function": {"name": "f", "arguments": {"a": 1}}}]}, "done": false}` + "\n" +
+				`{"done": true, "done_reason": "stop"}` + "\n"),
+			want:     []string{"message_start", "content_block_start:tool_use", "content_block_delta:input_json_delta", "content_block_stop", "message_delta", "message_stop"},
+			wantStop: "tool_use",
+		},
+		{
+			name:      "error chunk",
+			stream:    strings.NewReader(textChunk + `{"error": "model runner crashed"}` + "\n"),
+			want:      append(textTypes, "content_block_stop", "error"),
+			wantError: "model runner crashed",
+		},
+		{
+			name:      "read error",
+			stream:    &failingReader{data: strings.NewReader(textChunk), err: errors.New("connection reset")},
+			want:      append(textTypes, "content_block_stop", "error"),
+			wantError: "connection reset",
+		},
+		{
+			name:      "stream closed before done",
+			stream:    strings.NewReader(textChunk),
+			want:      append(textTypes, "content_block_stop", "error"),
+			wantError: "ended before",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := readAnthropicEvents(t, streamOllamaToAnthropic(io.NopCloser(tt.stream), "llama3"))
+			if got := eventTypes(events); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			last := events[len(events)-1]
+			if tt.wantError != "" {
+				message, _ := last.Data["error"].(map[string]interface{})["message"].(string)
+				if !strings.Contains(message, tt.wantError) {
+					t.Fatalf("error message = %q, want %q", message, tt.wantError)
+				}
+				return
+			}
+			delta := events[len(events)-2].Data["delta"].(map[string]interface{})
+			if delta["stop_reason"] != tt.wantStop {
+				t.Fatalf("stop_reason = %v, want %s", delta["stop_reason"], tt.wantStop)
+			}
+		})
+	}
+}
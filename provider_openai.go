@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	registerProvider("openai", newOpenAIProvider)
+}
+
+// openAIProvider OpenAI兼容的 /chat/completions 上游(OpenRouter等)
+type openAIProvider struct {
+	config UpstreamConfig
+	client *upstreamClient
+}
+
+// newOpenAIProvider 创建OpenAI兼容适配器
+func newOpenAIProvider(config UpstreamConfig) (Provider, error) {
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &openAIProvider{config: config, client: newUpstreamClient(config.HTTP)}, nil
+}
+
+func (p *openAIProvider) Name() string {
+	return "openai"
+}
+
+func (p *openAIProvider) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
+func (p *openAIProvider) Capabilities(model string) ProviderCapabilities {
+	return ProviderCapabilities{
+		Streaming: true,
+		Tools:     true,
+		FileInput: modelSupportsFileInput(model),
+		Images:    true,
+	}
+}
+
+func (p *openAIProvider) ConvertRequest(req *ProviderRequest) (interface{}, error) {
+	return formatAnthropicToOpenAI(req.Anthropic, req.Model, p.Capabilities(req.Model))
+}
+
+func (p *openAIProvider) Do(ctx context.Context, req *ProviderRequest, upstreamRequest interface{}) (*http.Response, error) {
+	requestBody, err := json.Marshal(upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", p.config.BaseURL+"/chat/completions", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Authorization", "Bearer "+req.APIKey)
+	for key, value := range p.config.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+
+	return p.client.Do(httpRequest)
+}
+
+func (p *openAIProvider) ConvertResponse(resp *http.Response, req *ProviderRequest) (interface{}, interface{}, error) {
+	var openaiResponse OpenAICompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&openaiResponse); err != nil {
+		return nil, nil, err
+	}
+	return openaiResponse, formatOpenAIToAnthropic(openaiResponse, req.Model), nil
+}
+
+func (p *openAIProvider) ConvertStream(resp *http.Response, req *ProviderRequest) io.ReadCloser {
+	return streamOpenAIToAnthropic(resp.Body, req.Model)
+}
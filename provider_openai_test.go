@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// openAITestServer 返回固定SSE流的OpenAI兼容服务，并记录收到的请求体
+// 与OpenAI一样，请求没有stream_options.include_usage时不返回usage块
+func openAITestServer(t *testing.T, body string) (*httptest.Server, *map[string]interface{}) {
+	t.Helper()
+	request := make(map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		options, _ := request["stream_options"].(map[string]interface{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range strings.SplitAfter(body, "\n\n") {
+			if options["include_usage"] != true && strings.Contains(event, `"usage"`) {
+				continue
+			}
+			io.WriteString(w, event)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &request
+}
+
+// openAIUsageStream 正文之后用一个choices为空的chunk返回usage，与include_usage时OpenAI的行为一致
+const openAIUsageStream = `data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}
+
+data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}
+
+data: {"id":"c1","choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":300,"total_tokens":1500}}
+
+data: [DONE]
+
+`
+
+// meterOpenAIStream 通过指定适配器发送流式请求，返回上游收到的请求体和计量到的用量
+func meterOpenAIStream(t *testing.T, newProvider func(UpstreamConfig) (Provider, error), config UpstreamConfig) (map[string]interface{}, AnthropicUsage) {
+	t.Helper()
+	server, request := openAITestServer(t, openAIUsageStream)
+	config.BaseURL = server.URL
+	provider, err := newProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &ProviderRequest{
+		Anthropic: parseAnthropicRequest(t, `{"model": "claude", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`),
+		Model:     "gpt-4o",
+		APIKey:    "test-key",
+	}
+	upstreamRequest, err := provider.ConvertRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := provider.Do(context.Background(), req, upstreamRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meter := newUsageMeter(provider.ConvertStream(resp, req))
+	if _, err := io.Copy(io.Discard, meter); err != nil {
+		t.Fatal(err)
+	}
+	meter.Close()
+	return *request, meter.Usage
+}
+
+func TestOpenAIProviderStreamUsage(t *testing.T) {
+	tests := []struct {
+		name        string
+		newProvider func(UpstreamConfig) (Provider, error)
+		config      UpstreamConfig
+	}{
+		{"openai", newOpenAIProvider, UpstreamConfig{}},
+		{"azure", newAzureProvider, UpstreamConfig{APIKey: "azure-key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, usage := meterOpenAIStream(t, tt.newProvider, tt.config)
+			options, _ := request["stream_options"].(map[string]interface{})
+			if options["include_usage"] != true {
+				t.Fatalf("stream_options = %v, want include_usage", request["stream_options"])
+			}
+			if usage.InputTokens != 1200 || usage.OutputTokens != 300 {
+				t.Fatalf("usage = %+v, want 1200 input and 300 output tokens", usage)
+			}
+			if cost := (ModelPrice{Input: 2.5, Output: 10}).cost(&usage); cost <= 0 {
+				t.Fatalf("cost = %v, want the streamed usage priced", cost)
+			}
+		})
+	}
+}
+
+func TestFormatAnthropicToOpenAIStreamOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"streaming requests usage", `{"model": "claude", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`, true},
+		{"non-streaming omits stream_options", `{"model": "claude", "messages": [{"role": "user", "content": "hi"}]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := formatAnthropicToOpenAI(parseAnthropicRequest(t, tt.body), "gpt-4o", ProviderCapabilities{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := request.StreamOptions != nil && request.StreamOptions.IncludeUsage; got != tt.want {
+				t.Fatalf("include_usage = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
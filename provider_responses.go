@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerProvider("responses", newResponsesProvider)
+}
+
+// responsesSignaturePrefix 标记由Responses推理项编码而来的thinking签名
+const responsesSignaturePrefix = "oairs:"
+
+// ResponsesRequest OpenAI Responses API请求格式
+type ResponsesRequest struct {
+	Model           string              `json:"model"`
+	Input           []ResponsesItem     `json:"input"`
+	Instructions    string              `json:"instructions,omitempty"`
+	Tools           []ResponsesTool     `json:"tools,omitempty"`
+	ToolChoice      interface{}         `json:"tool_choice,omitempty"`
+	MaxOutputTokens int                 `json:"max_output_tokens,omitempty"`
+	Temperature     *float64            `json:"temperature,omitempty"`
+	TopP            *float64            `json:"top_p,omitempty"`
+	Stream          bool                `json:"stream,omitempty"`
+	Reasoning       *ResponsesReasoning `json:"reasoning,omitempty"`
+	Include         []string            `json:"include,omitempty"`
+	Store           bool                `json:"store"`
+}
+
+// ResponsesItem Responses输入/输出项(message、function_call、function_call_output、reasoning)
+// reasoning项必须带summary字段，因此Summary使用指针以区分"空数组"和"不存在"
+type ResponsesItem struct {
+	Type             string                  `json:"type"`
+	ID               string                  `json:"id,omitempty"`
+	Role             string                  `json:"role,omitempty"`
+	Content          []ResponsesContentPart  `json:"content,omitempty"`
+	CallID           string                  `json:"call_id,omitempty"`
+	Name             string                  `json:"name,omitempty"`
+	Arguments        string                  `json:"arguments,omitempty"`
+	Output           string                  `json:"output,omitempty"`
+	Summary          *[]ResponsesContentPart `json:"summary,omitempty"`
+	EncryptedContent string                  `json:"encrypted_content,omitempty"`
+	Status           string                  `json:"status,omitempty"`
+}
+
+// ResponsesContentPart Responses消息内容部分
+type ResponsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+}
+
+// ResponsesTool Responses函数工具
+type ResponsesTool struct {
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Strict      bool                   `json:"strict"`
+}
+
+// ResponsesReasoning 推理配置
+type ResponsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// ResponsesResponse Responses API响应格式
+type ResponsesResponse struct {
+	ID                string          `json:"id"`
+	Object            string          `json:"object"`
+	Status            string          `json:"status"`
+	Model             string          `json:"model"`
+	Output            []ResponsesItem `json:"output"`
+	Usage             *ResponsesUsage `json:"usage,omitempty"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details,omitempty"`
+	Error *ResponsesError `json:"error,omitempty"`
+}
+
+// ResponsesError 响应失败的原因
+type ResponsesError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponsesUsage Responses用量统计
+type ResponsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ResponsesStreamEvent Responses流式事件
+type ResponsesStreamEvent struct {
+	Type         string             `json:"type"`
+	OutputIndex  int                `json:"output_index"`
+	SummaryIndex int                `json:"summary_index"`
+	ItemID       string             `json:"item_id,omitempty"`
+	Delta        string             `json:"delta,omitempty"`
+	Item         *ResponsesItem     `json:"item,omitempty"`
+	Response     *ResponsesResponse `json:"response,omitempty"`
+	Code         string             `json:"code,omitempty"`    // error事件
+	Message      string             `json:"message,omitempty"` // error事件
+}
+
+// responsesReasoningState 推理项的id和加密内容，编码后放入thinking签名中跨轮次传递
+type responsesReasoningState struct {
+	ID               string `json:"id"`
+	EncryptedContent string `json:"encrypted_content"`
+}
+
+// responsesProvider OpenAI /v1/responses 上游
+type responsesProvider struct {
+	config UpstreamConfig
+	client *upstreamClient
+}
+
+// newResponsesProvider 创建Responses API适配器
+func newResponsesProvider(config UpstreamConfig) (Provider, error) {
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &responsesProvider{config: config, client: newUpstreamClient(config.HTTP)}, nil
+}
+
+func (p *responsesProvider) Name() string {
+	return "responses"
+}
+
+func (p *responsesProvider) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
+func (p *responsesProvider) Capabilities(model string) ProviderCapabilities {
+	return ProviderCapabilities{
+		Streaming: true,
+		Tools:     true,
+		FileInput: true,
+		Images:    true,
+	}
+}
+
+func (p *responsesProvider) ConvertRequest(req *ProviderRequest) (interface{}, error) {
+	return formatAnthropicToResponses(req.Anthropic, req.Model)
+}
+
+func (p *responsesProvider) Do(ctx context.Context, req *ProviderRequest, upstreamRequest interface{}) (*http.Response, error) {
+	requestBody, err := json.Marshal(upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", p.config.BaseURL+"/responses", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Authorization", "Bearer "+req.APIKey)
+	for key, value := range p.config.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+
+	return p.client.Do(httpRequest)
+}
+
+func (p *responsesProvider) ConvertResponse(resp *http.Response, req *ProviderRequest) (interface{}, interface{}, error) {
+	var response ResponsesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil, err
+	}
+	return response, formatResponsesToAnthropic(response, req.Model), nil
+}
+
+func (p *responsesProvider) ConvertStream(resp *http.Response, req *ProviderRequest) io.ReadCloser {
+	return streamResponsesToAnthropic(resp.Body, req.Model)
+}
+
+// encodeResponsesReasoning 将推理项编码为thinking签名
+func encodeResponsesReasoning(item ResponsesItem) string {
+	if item.EncryptedContent == "" {
+		return ""
+	}
+	data, _ := json.Marshal(responsesReasoningState{ID: item.ID, EncryptedContent: item.EncryptedContent})
+	return responsesSignaturePrefix + base64.StdEncoding.EncodeToString(data)
+}
+
+// decodeResponsesReasoning 从thinking签名中还原推理项，非本适配器生成的签名返回false
+func decodeResponsesReasoning(signature string) (ResponsesItem, bool) {
+	if !strings.HasPrefix(signature, responsesSignaturePrefix) {
+		return ResponsesItem{}, false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(signature, responsesSignaturePrefix))
+	if err != nil {
+		return ResponsesItem{}, false
+	}
+	var state responsesReasoningState
+	if err := json.Unmarshal(data, &state); err != nil || state.EncryptedContent == "" {
+		return ResponsesItem{}, false
+	}
+	return ResponsesItem{
+		Type:             "reasoning",
+		ID:               state.ID,
+		Summary:          &[]ResponsesContentPart{},
+		EncryptedContent: state.EncryptedContent,
+	}, true
+}
+
+// reasoningEffort 根据思考预算推断推理强度
+func reasoningEffort(budgetTokens int) string {
+	switch {
+	case budgetTokens < 4096:
+		return "low"
+	case budgetTokens < 16384:
+		return "medium"
+	}
+	return "high"
+}
+
+// responsesMediaPart 将image/document块转换为Responses输入部分
+func responsesMediaPart(block ContentPart) (ResponsesContentPart, bool) {
+	if block.Source == nil {
+		return ResponsesContentPart{}, false
+	}
+	if block.Type == "image" {
+		switch block.Source.Type {
+		case "base64":
+			return ResponsesContentPart{Type: "input_image", ImageURL: "data:" + block.Source.MediaType + ";base64," + block.Source.Data}, true
+		case "url":
+			return ResponsesContentPart{Type: "input_image", ImageURL: block.Source.URL}, true
+		}
+		return ResponsesContentPart{}, false
+	}
+	if block.Source.Type == "base64" && block.Source.MediaType == "application/pdf" {
+		filename := block.Title
+		if filename == "" {
+			filename = "document.pdf"
+		}
+		return ResponsesContentPart{Type: "input_file", Filename: filename, FileData: "data:application/pdf;base64," + block.Source.Data}, true
+	}
+	return ResponsesContentPart{Type: "input_text", Text: convertDocumentBlock(block, false).Text}, true
+}
+
+// formatAnthropicToResponses 将Anthropic请求转换为Responses API请求
+func formatAnthropicToResponses(body MessageCreateParamsBase, model string) (ResponsesRequest, error) {
+	request := ResponsesRequest{
+		Model:           model,
+		Instructions:    systemPromptText(body.System),
+		MaxOutputTokens: body.MaxTokens,
+		Temperature:     body.Temperature,
+		TopP:            body.TopP,
+		Stream:          body.Stream,
+		Include:         []string{"reasoning.encrypted_content"},
+		Store:           false,
+	}
+
+	for _, message := range body.Messages {
+		if message.Role == "assistant" {
+			var textParts []ResponsesContentPart
+			flushText := func() {
+				if len(textParts) > 0 {
+					request.Input = append(request.Input, ResponsesItem{Type: "message", Role: "assistant", Content: textParts})
+					textParts = nil
+				}
+			}
+			for _, block := range contentBlocks(message.Content) {
+				switch block.Type {
+				case "text":
+					if block.Text != "" {
+						textParts = append(textParts, ResponsesContentPart{Type: "output_text", Text: block.Text})
+					}
+				case "thinking", "redacted_thinking":
+					signature := block.Signature
+					if block.Type == "redacted_thinking" {
+						signature = block.Data
+					}
+					if item, ok := decodeResponsesReasoning(signature); ok {
+						flushText()
+						if block.Thinking != "" {
+							item.Summary = &[]ResponsesContentPart{{Type: "summary_text", Text: block.Thinking}}
+						}
+						request.Input = append(request.Input, item)
+					}
+				case "tool_use":
+					flushText()
+					argsBytes, _ := json.Marshal(block.Input)
+					if block.Input == nil {
+						argsBytes = []byte("{}")
+					}
+					request.Input = append(request.Input, ResponsesItem{
+						Type:      "function_call",
+						CallID:    block.ID,
+						Name:      block.Name,
+						Arguments: string(argsBytes),
+					})
+				}
+			}
+			flushText()
+			continue
+		}
+
+		var parts []ResponsesContentPart
+		for _, block := range contentBlocks(message.Content) {
+			switch block.Type {
+			case "text":
+				if block.Text != "" {
+					parts = append(parts, ResponsesContentPart{Type: "input_text", Text: block.Text})
+				}
+			case "image", "document":
+				if part, ok := responsesMediaPart(block); ok {
+					parts = append(parts, part)
+				}
+			case "tool_result":
+				output := contentText(block.Content)
+				if block.IsError {
+					output = "Error: " + output
+				}
+				request.Input = append(request.Input, ResponsesItem{
+					Type:   "function_call_output",
+					CallID: block.ToolUseID,
+					Output: output,
+				})
+			}
+		}
+		if len(parts) > 0 {
+			request.Input = append(request.Input, ResponsesItem{Type: "message", Role: "user", Content: parts})
+		}
+	}
+
+	for _, tool := range body.Tools {
+		request.Tools = append(request.Tools, ResponsesTool{
+			Type:        "function",
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.InputSchema,
+		})
+	}
+	if body.ToolChoice != nil && len(request.Tools) > 0 {
+		switch body.ToolChoice.Type {
+		case "any":
+			request.ToolChoice = "required"
+		case "none":
+			request.ToolChoice = "none"
+		case "tool":
+			request.ToolChoice = map[string]string{"type": "function", "name": body.ToolChoice.Name}
+		default:
+			request.ToolChoice = "auto"
+		}
+	}
+
+	if body.Thinking != nil && body.Thinking.Type == "enabled" {
+		request.Reasoning = &ResponsesReasoning{
+			Effort:  reasoningEffort(body.Thinking.BudgetTokens),
+			Summary: "auto",
+		}
+		// 推理模型不接受采样参数
+		request.Temperature = nil
+		request.TopP = nil
+	}
+
+	return request, nil
+}
+
+// responsesStopReason 根据响应状态推断Anthropic的stop_reason
+func responsesStopReason(response *ResponsesResponse, hasToolUse bool) string {
+	if hasToolUse {
+		return "tool_use"
+	}
+	if response != nil && response.Status == "incomplete" && response.IncompleteDetails != nil && response.IncompleteDetails.Reason == "max_output_tokens" {
+		return "max_tokens"
+	}
+	return "end_turn"
+}
+
+// formatResponsesToAnthropic 将Responses API响应转换为Anthropic响应
+func formatResponsesToAnthropic(response ResponsesResponse, model string) AnthropicResponse {
+	var content []AnthropicContent
+	hasToolUse := false
+
+	for _, item := range response.Output {
+		switch item.Type {
+		case "reasoning":
+			var summary []string
+			if item.Summary != nil {
+				for _, part := range *item.Summary {
+					summary = append(summary, part.Text)
+				}
+			}
+			signature := encodeResponsesReasoning(item)
+			if len(summary) == 0 && signature != "" {
+				content = append(content, AnthropicContent{Type: "redacted_thinking", Data: signature})
+			} else if len(summary) > 0 {
+				content = append(content, AnthropicContent{
+					Type:      "thinking",
+					Thinking:  strings.Join(summary, "\n\n"),
+					Signature: signature,
+				})
+			}
+		case "message":
+			for _, part := range item.Content {
+				if part.Type == "output_text" && part.Text != "" {
+					content = append(content, AnthropicContent{Type: "text", Text: part.Text})
+				}
+			}
+		case "function_call":
+			hasToolUse = true
+			var input interface{} = map[string]interface{}{}
+			if item.Arguments != "" {
+				var args interface{}
+				if err := json.Unmarshal([]byte(item.Arguments), &args); err == nil {
+					input = args
+				}
+			}
+			content = append(content, AnthropicContent{
+				Type:  "tool_use",
+				ID:    item.CallID,
+				Name:  item.Name,
+				Input: input,
+			})
+		}
+	}
+
+	var usage AnthropicUsage
+	if response.Usage != nil {
+		usage = AnthropicUsage{
+			InputTokens:  response.Usage.InputTokens,
+			OutputTokens: response.Usage.OutputTokens,
+		}
+	}
+
+	return AnthropicResponse{
+		ID:           fmt.Sprintf("msg_%d", time.Now().UnixMilli()),
+		Type:         "message",
+		Role:         "assistant",
+		Content:      content,
+		StopReason:   responsesStopReason(&response, hasToolUse),
+		StopSequence: nil,
+		Model:        model,
+		Usage:        usage,
+	}
+}
+
+// streamResponsesToAnthropic 将Responses API流式事件转换为Anthropic流式响应
+func streamResponsesToAnthropic(responsesStream io.ReadCloser, model string) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer pw.Close()
+		defer responsesStream.Close()
+
+		writer := newAnthropicStreamWriter(pw, model)
+		var finalResponse *ResponsesResponse
+		finished := false
+		streamError := ""
+
+		err := readSSEData(responsesStream, func(eventType, data string) {
+			if finished || streamError != "" {
+				return
+			}
+			var event ResponsesStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				return
+			}
+
+			switch event.Type {
+			case "response.output_item.added":
+				if event.Item == nil {
+					return
+				}
+				switch event.Item.Type {
+				case "reasoning":
+					writer.CloseBlock()
+				case "function_call":
+					writer.StartToolUse(event.Item.CallID, event.Item.Name)
+				}
+			case "response.reasoning_summary_text.delta":
+				writer.Thinking(event.Delta)
+			case "response.reasoning_summary_part.added":
+				// 多个摘要段之间以空行分隔
+				if event.SummaryIndex > 0 {
+					writer.Thinking("\n\n")
+				}
+			case "response.output_text.delta":
+				writer.Text(event.Delta)
+			case "response.function_call_arguments.delta":
+				writer.ToolInput(event.Delta)
+			case "response.output_item.done":
+				if event.Item == nil {
+					return
+				}
+				switch event.Item.Type {
+				case "reasoning":
+					if signature := encodeResponsesReasoning(*event.Item); signature != "" {
+						if writer.blockType == "thinking" {
+							writer.Signature(signature)
+						} else {
+							writer.startBlock("redacted_thinking", map[string]interface{}{"type": "redacted_thinking", "data": signature})
+						}
+					}
+					writer.CloseBlock()
+				case "function_call", "message":
+					writer.CloseBlock()
+				}
+			case "response.completed", "response.incomplete":
+				finished = true
+				finalResponse = event.Response
+				if event.Response != nil && event.Response.Usage != nil {
+					writer.InputTokens = event.Response.Usage.InputTokens
+					writer.OutputTokens = event.Response.Usage.OutputTokens
+				}
+			case "response.failed":
+				streamError = "Upstream response failed"
+				if event.Response != nil && event.Response.Error != nil {
+					streamError += ": " + event.Response.Error.Message
+				}
+			case "error":
+				streamError = fmt.Sprintf("Upstream error %s: %s", event.Code, event.Message)
+			}
+		})
+
+		switch {
+		case streamError != "":
+			writer.Error(streamError)
+		case err != nil:
+			writer.Error("Upstream stream read failed: " + err.Error())
+		case !finished:
+			writer.Error("Upstream stream ended before the response was complete")
+		default:
+			writer.Finish(responsesStopReason(finalResponse, writer.hasToolUse))
+		}
+	}()
+
+	return pr
+}
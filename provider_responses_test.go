@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// failingReader 读完data后返回err，模拟连接中断
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+// responsesSSE 把Responses事件编码为SSE流
+func responsesSSE(events ...string) string {
+	var b strings.Builder
+	for _, event := range events {
+		b.WriteString("data: " + event + "\n\n")
+	}
+	return b.String()
+}
+
+func TestStreamResponsesToAnthropic(t *testing.T) {
+	textEvents := []string{
+		`{"type": "response.output_item.added", "item": {"type": "message"}}`,
+		`{"type": "response.output_text.delta", "delta": "Hel"}`,
+		`{"type": "response.output_text.delta", "delta": "lo"}`,
+	}
+	textTypes := []string{"message_start", "content_block_start:text", "content_block_delta:text_delta", "content_block_delta:text_delta"}
+	tests := []struct {
+		name      string
+		stream    io.Reader
+		want      []string
+		wantStop  string
+		wantError string
+	}{
+		{
+			name: "completed",
+			stream: strings.NewReader(responsesSSE(append(textEvents,
+				`{"type": "response.output_item.done", "item": {"type": "message"}}`,
+				`{"type": "response.completed", "response": {"status": "completed", "usage": {"input_tokens": 3, "output_tokens": 2}}}`)...)),
+			want:     append(textTypes, "content_block_stop", "message_delta", "message_stop"),
+			wantStop: "end_turn",
+		},
+		{
+			name: "incomplete at max tokens",
+			stream: strings.NewReader(responsesSSE(append(textEvents,
+				`{"type": "response.incomplete", "response": {"status": "incomplete", "incomplete_details": {"reason": "max_output_tokens"}}}`)...)),
+			want:     append(textTypes, "content_block_stop", "message_delta", "message_stop"),
+			wantStop: "max_tokens",
+		},
+		{
+			name: "function call",
+			stream: strings.NewReader(responsesSSE(
+				`{"type": "response.output_item.added", "item": {"type": "function_call", "call_id": "call_1", "name": "f"}}`,
+				`{"type": "response.function_call_arguments.delta", "delta": "{}"}`,
+				`{"type": "response.output_item.done", "item": {"type": "function_call"}}`,
+				`{"type": "response.completed", "response": {"status": "completed"}}`)),
+			want:     []string{"message_start", "content_block_start:tool_use", "content_block_delta:input_json_delta", "content_block_stop", "message_delta", "message_stop"},
+			wantStop: "tool_use",
+		},
+		{
+			name: "response failed",
+			stream: strings.NewReader(responsesSSE(append(textEvents,
+				`{"type": "response.failed", "response": {"status": "failed", "error": {"code": "server_error", "message": "boom"}}}`)...)),
+			want:      append(textTypes, "content_block_stop", "error"),
+			wantError: "boom",
+		},
+		{
+			name: "error event",
+			stream: strings.NewReader(responsesSSE(append(textEvents,
+				`{"type": "error", "code": "rate_limit_exceeded", "message": "slow down"}`)...)),
+			want:      append(textTypes, "content_block_stop", "error"),
+			wantError: "slow down",
+		},
+		{
+			name:      "read error",
+			stream:    &failingReader{data: strings.NewReader(responsesSSE(textEvents...)), err: errors.New("connection reset")},
+			want:      append(textTypes, "content_block_stop", "error"),
+			wantError: "connection reset",
+		},
+		{
+			name:      "stream closed before completion",
+			stream:    strings.NewReader(responsesSSE(textEvents...)),
+			want:      append(textTypes, "content_block_stop", "error"),
+			wantError: "ended before",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := readAnthropicEvents(t, streamResponsesToAnthropic(io.NopCloser(tt.stream), "gpt-5"))
+			if got := eventTypes(events); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			last := events[len(events)-1]
+			if tt.wantError != "" {
+				message, _ := last.Data["error"].(map[string]interface{})["message"].(string)
+				if !strings.Contains(message, tt.wantError) {
+					t.Fatalf("error message = %q, want %q", message, tt.wantError)
+				}
+				return
+			}
+			delta := events[len(events)-2].Data["delta"].(map[string]interface{})
+			if delta["stop_reason"] != tt.wantStop {
+				t.Fatalf("stop_reason = %v, want %s", delta["stop_reason"], tt.wantStop)
+			}
+		})
+	}
+}
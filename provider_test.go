@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeProvider 测试用上游适配器，未设置的行为使用默认实现
+type fakeProvider struct {
+	convert func(req *ProviderRequest) (interface{}, error)
+	do      func(ctx context.Context, req *ProviderRequest) (*http.Response, error)
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Capabilities(model string) ProviderCapabilities {
+	return ProviderCapabilities{Streaming: true, Tools: true, FileInput: true, Images: true}
+}
+
+func (p *fakeProvider) ConvertRequest(req *ProviderRequest) (interface{}, error) {
+	if p.convert != nil {
+		return p.convert(req)
+	}
+	return req.Model, nil
+}
+
+func (p *fakeProvider) Do(ctx context.Context, req *ProviderRequest, upstreamRequest interface{}) (*http.Response, error) {
+	if p.do != nil {
+		return p.do(ctx, req)
+	}
+	return fakeResponse(http.StatusOK, "data: {}\n\n"), nil
+}
+
+func (p *fakeProvider) ConvertResponse(resp *http.Response, req *ProviderRequest) (interface{}, interface{}, error) {
+	return nil, nil, nil
+}
+
+func (p *fakeProvider) ConvertStream(resp *http.Response, req *ProviderRequest) io.ReadCloser {
+	return resp.Body
+}
+
+// fakeResponse 生成指定状态码和响应体的上游响应
+func fakeResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+// useTestUpstreams 临时替换全局上游表，测试结束后恢复
+func useTestUpstreams(t *testing.T, providers map[string]Provider, defaultName string) map[string]*Upstream {
+	t.Helper()
+	routingMu.Lock()
+	savedUpstreams, savedDefault := upstreams, defaultUpstream
+	upstreams = make(map[string]*Upstream)
+	for name, provider := range providers {
+		upstreams[name] = &Upstream{Name: name, Provider: provider, Retry: newRetryPolicy(nil)}
+	}
+	defaultUpstream = upstreams[defaultName]
+	built := upstreams
+	routingMu.Unlock()
+	t.Cleanup(func() {
+		routingMu.Lock()
+		upstreams, defaultUpstream = savedUpstreams, savedDefault
+		routingMu.Unlock()
+	})
+	return built
+}
+
+// sseEvent 解析后的Anthropic SSE事件
+type sseEvent struct {
+	Type string
+	Data map[string]interface{}
+}
+
+// readAnthropicEvents 读取转换后的Anthropic SSE流
+func readAnthropicEvents(t *testing.T, stream io.Reader) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	err := readSSEData(stream, func(event, data string) {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+			t.Fatalf("invalid event data %q: %v", data, err)
+		}
+		events = append(events, sseEvent{Type: event, Data: decoded})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// eventTypes 返回事件类型序列，内容块事件附带块类型或增量类型
+func eventTypes(events []sseEvent) []string {
+	var types []string
+	for _, event := range events {
+		name := event.Type
+		if block, ok := event.Data["content_block"].(map[string]interface{}); ok {
+			name += ":" + block["type"].(string)
+		}
+		if delta, ok := event.Data["delta"].(map[string]interface{}); ok && event.Type == "content_block_delta" {
+			name += ":" + delta["type"].(string)
+		}
+		types = append(types, name)
+	}
+	return types
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig 一个主体(虚拟密钥或客户端IP)的限流配置，0表示不限制
+type RateLimitConfig struct {
+	RequestsPerMinute     int `json:"requests_per_minute,omitempty"`
+	InputTokensPerMinute  int `json:"input_tokens_per_minute,omitempty"`
+	OutputTokensPerMinute int `json:"output_tokens_per_minute,omitempty"`
+	ConcurrentStreams     int `json:"concurrent_streams,omitempty"`
+}
+
+// RateLimitSettings 全局限流配置
+type RateLimitSettings struct {
+	PerKey RateLimitConfig `json:"per_key"` // 每个API密钥(虚拟密钥可单独覆盖)
+	PerIP  RateLimitConfig `json:"per_ip"`  // 每个客户端IP
+}
+
+// empty 判断配置是否没有任何限制
+func (c RateLimitConfig) empty() bool {
+	return c == RateLimitConfig{}
+}
+
+// tokenBucket 令牌桶，容量为每分钟限额，按限额/60每秒匀速补充
+// 余额可以为负：输出token在响应结束后才扣除，超用的部分需要等待补回
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	updated  time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &tokenBucket{capacity: float64(perMinute), tokens: float64(perMinute), updated: now}
+}
+
+// refill 按经过的时间补充令牌
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.capacity/60)
+		b.updated = now
+	}
+}
+
+// wait 返回余额达到need所需的等待时间
+func (b *tokenBucket) wait(need float64) time.Duration {
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) * 60 / b.capacity * float64(time.Second))
+}
+
+// reset 返回令牌桶补满的时间
+func (b *tokenBucket) reset(now time.Time) time.Time {
+	return now.Add(b.wait(b.capacity))
+}
+
+// full 判断令牌桶是否已满
+func (b *tokenBucket) full() bool {
+	return b == nil || b.tokens >= b.capacity
+}
+
+// limiterState 一个主体的限流状态
+type limiterState struct {
+	config  RateLimitConfig
+	request *tokenBucket
+	input   *tokenBucket
+	output  *tokenBucket
+	streams int
+}
+
+// rateSubject 参与限流的主体
+type rateSubject struct {
+	key    string
+	config RateLimitConfig
+}
+
+// rateLimiter 按主体限制请求数、输入/输出token数和并发流数
+type rateLimiter struct {
+	mu        sync.Mutex
+	states    map[string]*limiterState
+	lastPrune time.Time
+}
+
+var rateLimits = &rateLimiter{states: make(map[string]*limiterState)}
+
+// rateLimitError 限流拒绝
+type rateLimitError struct {
+	message    string
+	retryAfter time.Duration
+	headers    http.Header
+}
+
+func (e *rateLimitError) Error() string {
+	return e.message
+}
+
+// rateLease 已放行请求的限流占用，请求结束时按实际用量结算
+type rateLease struct {
+	limiter        *rateLimiter
+	states         []*limiterState
+	estimatedInput int
+	stream         bool
+	finished       bool
+	Headers        http.Header
+}
+
+// rateSubjects 返回请求需要检查的限流主体
+func rateSubjects(client clientCredentials, clientIP string) []rateSubject {
+	var subjects []rateSubject
+	keyConfig := env.RateLimits.PerKey
+	if client.VirtualKey != nil && client.VirtualKey.RateLimit != nil {
+		keyConfig = *client.VirtualKey.RateLimit
+	}
+	if !keyConfig.empty() {
+		subjects = append(subjects, rateSubject{key: "key:" + client.ID(), config: keyConfig})
+	}
+	if !env.RateLimits.PerIP.empty() && clientIP != "" {
+		subjects = append(subjects, rateSubject{key: "ip:" + clientIP, config: env.RateLimits.PerIP})
+	}
+	return subjects
+}
+
+// state 返回主体的限流状态，配置变化时重新创建
+func (l *rateLimiter) state(subject rateSubject, now time.Time) *limiterState {
+	state, ok := l.states[subject.key]
+	if !ok {
+		state = &limiterState{}
+		l.states[subject.key] = state
+	}
+	// 原地更新，进行中请求的租约仍然指向同一个状态
+	if !ok || state.config != subject.config {
+		state.config = subject.config
+		state.request = newTokenBucket(subject.config.RequestsPerMinute, now)
+		state.input = newTokenBucket(subject.config.InputTokensPerMinute, now)
+		state.output = newTokenBucket(subject.config.OutputTokensPerMinute, now)
+	}
+	for _, bucket := range []*tokenBucket{state.request, state.input, state.output} {
+		if bucket != nil {
+			bucket.refill(now)
+		}
+	}
+	return state
+}
+
+// Admit 检查所有主体的限额，全部通过时一并扣除请求数和估算的输入token
+func (l *rateLimiter) Admit(subjects []rateSubject, inputTokens int, stream bool) (*rateLease, *rateLimitError) {
+	if len(subjects) == 0 {
+		return nil, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	states := make([]*limiterState, len(subjects))
+	var rejection *rateLimitError
+	for i, subject := range subjects {
+		state := l.state(subject, now)
+		states[i] = state
+		if rejection == nil {
+			rejection = state.check(inputTokens, stream)
+		}
+	}
+	if rejection != nil {
+		rejection.headers = rateLimitHeaders(states, now)
+		retryAfter := int(math.Ceil(rejection.retryAfter.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		rejection.headers.Set("Retry-After", strconv.Itoa(retryAfter))
+		return nil, rejection
+	}
+
+	for _, state := range states {
+		if state.request != nil {
+			state.request.tokens--
+		}
+		if state.input != nil {
+			state.input.tokens -= float64(inputTokens)
+		}
+		if stream {
+			state.streams++
+		}
+	}
+	return &rateLease{
+		limiter:        l,
+		states:         states,
+		estimatedInput: inputTokens,
+		stream:         stream,
+		Headers:        rateLimitHeaders(states, now),
+	}, nil
+}
+
+// check 检查单个主体是否还能接受请求
+func (s *limiterState) check(inputTokens int, stream bool) *rateLimitError {
+	if s.request != nil && s.request.tokens < 1 {
+		return &rateLimitError{
+			message:    fmt.Sprintf("Number of requests has exceeded your per-minute rate limit (%d)", s.config.RequestsPerMinute),
+			retryAfter: s.request.wait(1),
+		}
+	}
+	if s.input != nil {
+		// 单个请求超过整分钟限额时按满额计算，否则永远无法通过
+		need := math.Min(float64(inputTokens), s.input.capacity)
+		if s.input.tokens < need {
+			return &rateLimitError{
+				message:    fmt.Sprintf("Number of input tokens has exceeded your per-minute rate limit (%d)", s.config.InputTokensPerMinute),
+				retryAfter: s.input.wait(need),
+			}
+		}
+	}
+	if s.output != nil && s.output.tokens <= 0 {
+		return &rateLimitError{
+			message:    fmt.Sprintf("Number of output tokens has exceeded your per-minute rate limit (%d)", s.config.OutputTokensPerMinute),
+			retryAfter: s.output.wait(1),
+		}
+	}
+	if stream && s.config.ConcurrentStreams > 0 && s.streams >= s.config.ConcurrentStreams {
+		return &rateLimitError{
+			message:    fmt.Sprintf("Number of concurrent streams has exceeded your limit (%d)", s.config.ConcurrentStreams),
+			retryAfter: time.Second,
+		}
+	}
+	return nil
+}
+
+// Finish 按实际用量结算：补差输入token、扣除输出token并释放并发流
+// usage为nil时(如上游返回错误)保留估算的输入token
+func (r *rateLease) Finish(usage *AnthropicUsage) {
+	if r == nil || r.finished {
+		return
+	}
+	r.finished = true
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+
+	now := time.Now()
+	for _, state := range r.states {
+		if usage != nil {
+			if state.input != nil && usage.InputTokens > 0 {
+				state.input.refill(now)
+				state.input.tokens -= float64(usage.InputTokens - r.estimatedInput)
+			}
+			if state.output != nil {
+				state.output.refill(now)
+				state.output.tokens -= float64(usage.OutputTokens)
+			}
+		}
+		if r.stream && state.streams > 0 {
+			state.streams--
+		}
+	}
+}
+
+// prune 定期移除已经补满且没有进行中流的主体
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for key, state := range l.states {
+		for _, bucket := range []*tokenBucket{state.request, state.input, state.output} {
+			if bucket != nil {
+				bucket.refill(now)
+			}
+		}
+		if state.streams == 0 && state.request.full() && state.input.full() && state.output.full() {
+			delete(l.states, key)
+		}
+	}
+}
+
+// rateLimitHeaders 生成anthropic-ratelimit-*响应头，每一项取所有主体中剩余最少的一个
+func rateLimitHeaders(states []*limiterState, now time.Time) http.Header {
+	header := http.Header{}
+	set := func(name string, pick func(*limiterState) *tokenBucket) {
+		var tightest *tokenBucket
+		for _, state := range states {
+			bucket := pick(state)
+			if bucket != nil && (tightest == nil || bucket.tokens < tightest.tokens) {
+				tightest = bucket
+			}
+		}
+		if tightest == nil {
+			return
+		}
+		prefix := "anthropic-ratelimit-" + name
+		header.Set(prefix+"-limit", strconv.Itoa(int(tightest.capacity)))
+		header.Set(prefix+"-remaining", strconv.Itoa(int(math.Max(0, math.Floor(tightest.tokens)))))
+		header.Set(prefix+"-reset", tightest.reset(now).UTC().Format(time.RFC3339))
+	}
+	set("requests", func(s *limiterState) *tokenBucket { return s.request })
+	set("input-tokens", func(s *limiterState) *tokenBucket { return s.input })
+	set("output-tokens", func(s *limiterState) *tokenBucket { return s.output })
+	return header
+}
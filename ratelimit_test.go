@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		tokens   float64
+		elapsed  time.Duration
+		want     float64
+		wantWait time.Duration
+	}{
+		{"no time passed", 0, 0, 0, time.Second},
+		{"one second refills one sixtieth", 0, time.Second, 1, 0},
+		{"half a minute", 10, 30 * time.Second, 40, 0},
+		{"capped at capacity", 50, time.Hour, 60, 0},
+		{"negative balance recovers", -30, 15 * time.Second, -15, 16 * time.Second},
+		{"clock going backwards is ignored", 5, -time.Minute, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := newTokenBucket(60, start)
+			bucket.tokens = tt.tokens
+			bucket.refill(start.Add(tt.elapsed))
+			if bucket.tokens != tt.want {
+				t.Fatalf("tokens = %v, want %v", bucket.tokens, tt.want)
+			}
+			if got := bucket.wait(1); got != tt.wantWait {
+				t.Fatalf("wait(1) = %v, want %v", got, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAdmit(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   RateLimitConfig
+		requests []int // 每个请求的估算输入token
+		stream   bool
+		rejected int // 第一个被拒绝的请求下标，-1表示全部放行
+	}{
+		{"requests per minute", RateLimitConfig{RequestsPerMinute: 2}, []int{1, 1, 1}, false, 2},
+		{"input tokens per minute", RateLimitConfig{InputTokensPerMinute: 100}, []int{60, 30, 20}, false, 2},
+		{"oversized request is admitted against a full bucket", RateLimitConfig{InputTokensPerMinute: 100}, []int{500}, false, -1},
+		{"concurrent streams", RateLimitConfig{ConcurrentStreams: 1}, []int{1, 1}, true, 1},
+		{"streams limit ignores non-streaming requests", RateLimitConfig{ConcurrentStreams: 1}, []int{1, 1}, false, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &rateLimiter{states: make(map[string]*limiterState), lastPrune: time.Now()}
+			subjects := []rateSubject{{key: "key:test", config: tt.config}}
+			rejected := -1
+			for i, tokens := range tt.requests {
+				if _, rejection := limiter.Admit(subjects, tokens, tt.stream); rejection != nil {
+					rejected = i
+					if rejection.headers.Get("Retry-After") == "" {
+						t.Fatal("rejection without Retry-After")
+					}
+					break
+				}
+			}
+			if rejected != tt.rejected {
+				t.Fatalf("first rejected request = %d, want %d", rejected, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestRateLeaseFinishRefunds(t *testing.T) {
+	limiter := &rateLimiter{states: make(map[string]*limiterState), lastPrune: time.Now()}
+	subjects := []rateSubject{{key: "key:test", config: RateLimitConfig{InputTokensPerMinute: 100, OutputTokensPerMinute: 50, ConcurrentStreams: 1}}}
+	lease, rejection := limiter.Admit(subjects, 80, true)
+	if rejection != nil {
+		t.Fatalf("Admit: %v", rejection)
+	}
+	lease.Finish(&AnthropicUsage{InputTokens: 10, OutputTokens: 60})
+	lease.Finish(&AnthropicUsage{InputTokens: 10, OutputTokens: 60})
+
+	state := limiter.states["key:test"]
+	if state.streams != 0 {
+		t.Fatalf("streams = %d after finishing, want 0", state.streams)
+	}
+	if state.input.tokens < 89 {
+		t.Fatalf("input tokens = %v, want the overestimate refunded", state.input.tokens)
+	}
+	if state.output.tokens > -9 {
+		t.Fatalf("output tokens = %v, want charged once", state.output.tokens)
+	}
+	if _, rejection := limiter.Admit(subjects, 1, false); rejection == nil {
+		t.Fatal("admitted a request while the output budget is negative")
+	}
+}
+
+func TestRateLeaseFinishChargesStreamedOpenAIUsage(t *testing.T) {
+	limiter := &rateLimiter{states: make(map[string]*limiterState), lastPrune: time.Now()}
+	subjects := []rateSubject{{key: "key:test", config: RateLimitConfig{OutputTokensPerMinute: 200}}}
+	tests := []struct {
+		name        string
+		newProvider func(UpstreamConfig) (Provider, error)
+	}{
+		{"openai", newOpenAIProvider},
+		{"azure", newAzureProvider},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter.states = make(map[string]*limiterState)
+			lease, rejection := limiter.Admit(subjects, 0, true)
+			if rejection != nil {
+				t.Fatalf("Admit: %v", rejection)
+			}
+			_, usage := meterOpenAIStream(t, tt.newProvider, UpstreamConfig{APIKey: "key"})
+			lease.Finish(&usage)
+			// 流末尾的usage为300个输出token，超过每分钟200的限额
+			if _, rejection := limiter.Admit(subjects, 0, true); rejection == nil {
+				t.Fatal("admitted a request after the streamed output exceeded the limit")
+			}
+		})
+	}
+}
+
+func TestRateSubjectsClientIP(t *testing.T) {
+	previous := env.RateLimits
+	env.RateLimits = RateLimitSettings{PerIP: RateLimitConfig{RequestsPerMinute: 10}}
+	t.Cleanup(func() { env.RateLimits = previous })
+
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		want           string
+	}{
+		{"forwarded header ignored by default", nil, "203.0.113.7:5000", "ip:203.0.113.7"},
+		{"untrusted proxy cannot spoof", []string{"10.0.0.0/8"}, "203.0.113.7:5000", "ip:203.0.113.7"},
+		{"trusted proxy forwards client IP", []string{"10.0.0.0/8"}, "10.1.2.3:5000", "ip:198.51.100.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			if err := r.SetTrustedProxies(tt.trustedProxies); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			r.GET("/", func(c *gin.Context) {
+				for _, subject := range rateSubjects(clientCredentials{}, c.ClientIP()) {
+					got = subject.key
+				}
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "198.51.100.9")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Fatalf("subject = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// RuleConditions 规则的请求特征条件，所有已设置的条件都满足时规则才匹配
+type RuleConditions struct {
+	Thinking       *bool    `json:"thinking,omitempty"`         // 是否启用了扩展思考
+	MinInputTokens int      `json:"min_input_tokens,omitempty"` // 估算输入token数不少于该值
+	MaxInputTokens int      `json:"max_input_tokens,omitempty"` // 估算输入token数不超过该值
+	Tools          []string `json:"tools,omitempty"`            // 包含任一工具(按名称或类型前缀匹配，如 web_search)
+	AnthropicBeta  string   `json:"anthropic_beta,omitempty"`   // anthropic-beta 请求头包含该值
+	System         string   `json:"system,omitempty"`           // system提示词匹配该正则
+
+	systemRe *regexp.Regexp
+}
+
+// requestFeatures 用于路由的请求特征
+type requestFeatures struct {
+	Thinking      bool
+	InputTokens   int
+	Tools         []Tool
+	AnthropicBeta string
+	System        string
+	UserID        string // metadata.user_id，用于A/B分流的粘性分配
+	APIKey        string // 客户端密钥，没有user_id时用于粘性分配
+}
+
+// extractRequestFeatures 提取请求特征
+func extractRequestFeatures(request MessageCreateParamsBase, header http.Header, apiKey string) *requestFeatures {
+	features := &requestFeatures{
+		Thinking:      request.Thinking != nil && request.Thinking.Type == "enabled",
+		InputTokens:   estimateInputTokens(request),
+		Tools:         request.Tools,
+		AnthropicBeta: strings.Join(header.Values("anthropic-beta"), ","),
+		System:        systemPromptText(request.System),
+		APIKey:        apiKey,
+	}
+	if request.Metadata != nil {
+		features.UserID = request.Metadata.UserID
+	}
+	return features
+}
+
+// compile 编译条件中的正则
+func (c *RuleConditions) compile() error {
+	if c.System == "" {
+		return nil
+	}
+	re, err := regexp.Compile(c.System)
+	if err != nil {
+		return fmt.Errorf("invalid system pattern %q: %w", c.System, err)
+	}
+	c.systemRe = re
+	return nil
+}
+
+// matches 判断请求特征是否满足条件；没有请求特征时(如按模型名调试路由)有条件的规则不匹配
+func (c *RuleConditions) matches(features *requestFeatures) bool {
+	if c == nil {
+		return true
+	}
+	if features == nil {
+		return false
+	}
+	if c.Thinking != nil && *c.Thinking != features.Thinking {
+		return false
+	}
+	if c.MinInputTokens > 0 && features.InputTokens < c.MinInputTokens {
+		return false
+	}
+	if c.MaxInputTokens > 0 && features.InputTokens > c.MaxInputTokens {
+		return false
+	}
+	if len(c.Tools) > 0 && !hasAnyTool(features.Tools, c.Tools) {
+		return false
+	}
+	if c.AnthropicBeta != "" && !strings.Contains(features.AnthropicBeta, c.AnthropicBeta) {
+		return false
+	}
+	if c.systemRe != nil && !c.systemRe.MatchString(features.System) {
+		return false
+	}
+	return true
+}
+
+// hasAnyTool 判断请求是否包含任一指定工具，服务端工具(如 web_search_20250305)按类型前缀匹配
+func hasAnyTool(tools []Tool, names []string) bool {
+	for _, tool := range tools {
+		for _, name := range names {
+			if tool.Name == name || (tool.Type != "" && strings.HasPrefix(tool.Type, name)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// estimateInputTokens 按约4个字符一个token粗略估算请求的输入token数
+func estimateInputTokens(request MessageCreateParamsBase) int {
+	chars := len(systemPromptText(request.System))
+	for _, message := range request.Messages {
+		for _, block := range contentBlocks(message.Content) {
+			chars += len(block.Text) + len(block.Thinking)
+			if block.Input != nil {
+				input, _ := json.Marshal(block.Input)
+				chars += len(input)
+			}
+			chars += len(contentText(block.Content))
+		}
+	}
+	for _, tool := range request.Tools {
+		schema, _ := json.Marshal(tool.InputSchema)
+		chars += len(tool.Name) + len(tool.Description) + len(schema)
+	}
+	return (chars + 3) / 4
+}
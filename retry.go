@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryConfig 上游重试策略
+type RetryConfig struct {
+	MaxAttempts      int     `json:"max_attempts"`                 // 包括首次请求在内的最大尝试次数
+	InitialBackoffMs int     `json:"initial_backoff_ms,omitempty"` // 首次重试前的等待时间
+	MaxBackoffMs     int     `json:"max_backoff_ms,omitempty"`     // 指数退避的上限
+	Multiplier       float64 `json:"multiplier,omitempty"`         // 每次重试的退避倍数
+	Jitter           float64 `json:"jitter,omitempty"`             // 随机抖动比例，0-1
+	RetryableStatus  []int   `json:"retryable_status,omitempty"`   // 可重试的状态码
+	MaxRetryAfterMs  int     `json:"max_retry_after_ms,omitempty"` // Retry-After超过该值时不再重试，直接切换备用目标
+}
+
+// retryPolicy 补全默认值后的重试策略
+type retryPolicy struct {
+	maxAttempts     int
+	initialBackoff  time.Duration
+	maxBackoff      time.Duration
+	multiplier      float64
+	jitter          float64
+	retryableStatus map[int]bool
+	maxRetryAfter   time.Duration
+}
+
+// newRetryPolicy 根据配置创建重试策略，未配置时只请求一次
+func newRetryPolicy(config *RetryConfig) retryPolicy {
+	policy := retryPolicy{
+		maxAttempts:     1,
+		initialBackoff:  500 * time.Millisecond,
+		maxBackoff:      10 * time.Second,
+		multiplier:      2,
+		jitter:          0.2,
+		retryableStatus: map[int]bool{408: true, 429: true, 500: true, 502: true, 503: true, 504: true},
+		maxRetryAfter:   60 * time.Second,
+	}
+	if config == nil {
+		return policy
+	}
+
+	if config.MaxAttempts > 0 {
+		policy.maxAttempts = config.MaxAttempts
+	}
+	if config.InitialBackoffMs > 0 {
+		policy.initialBackoff = time.Duration(config.InitialBackoffMs) * time.Millisecond
+	}
+	if config.MaxBackoffMs > 0 {
+		policy.maxBackoff = time.Duration(config.MaxBackoffMs) * time.Millisecond
+	}
+	if config.Multiplier >= 1 {
+		policy.multiplier = config.Multiplier
+	}
+	if config.Jitter > 0 && config.Jitter <= 1 {
+		policy.jitter = config.Jitter
+	}
+	if len(config.RetryableStatus) > 0 {
+		policy.retryableStatus = make(map[int]bool)
+		for _, status := range config.RetryableStatus {
+			policy.retryableStatus[status] = true
+		}
+	}
+	if config.MaxRetryAfterMs > 0 {
+		policy.maxRetryAfter = time.Duration(config.MaxRetryAfterMs) * time.Millisecond
+	}
+	return policy
+}
+
+// backoff 计算第retry次重试(从1开始)前的等待时间
+func (p retryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.initialBackoff) * math.Pow(p.multiplier, float64(retry-1))
+	if delay > float64(p.maxBackoff) {
+		delay = float64(p.maxBackoff)
+	}
+	delay *= 1 + p.jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// retryDelay 计算响应失败后的等待时间
+// 上游通过Retry-After或x-ratelimit-reset给出等待时间时优先使用；超过上限时返回false，不再重试
+func (p retryPolicy) retryDelay(resp *http.Response, retry int) (time.Duration, bool) {
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp.Header, time.Now()); ok {
+			if wait > p.maxRetryAfter {
+				return 0, false
+			}
+			return wait, true
+		}
+	}
+	return p.backoff(retry), true
+}
+
+// parseRetryAfter 解析Retry-After(秒数或HTTP日期)和x-ratelimit-reset(秒数、时长、Unix秒或毫秒时间戳)
+func parseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return nonNegative(time.Duration(seconds * float64(time.Second))), true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return nonNegative(at.Sub(now)), true
+		}
+	}
+
+	if value := strings.TrimSpace(header.Get("X-Ratelimit-Reset")); value != "" {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			switch {
+			case number > 1e12:
+				return nonNegative(time.UnixMilli(int64(number)).Sub(now)), true
+			case number > 1e9:
+				return nonNegative(time.Unix(int64(number), 0).Sub(now)), true
+			default:
+				return nonNegative(time.Duration(number * float64(time.Second))), true
+			}
+		}
+		if duration, err := time.ParseDuration(value); err == nil {
+			return nonNegative(duration), true
+		}
+	}
+	return 0, false
+}
+
+// nonNegative 将负的等待时间截断为0
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// sleepContext 等待指定时间，请求被取消时提前返回false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// anthropicStreamWriter 按Anthropic SSE协议写出消息与内容块事件
+// 供非OpenAI上游的流转换复用，负责内容块索引、块切换以及结束事件
+type anthropicStreamWriter struct {
+	pw           *io.PipeWriter
+	index        int
+	blockType    string
+	hasToolUse   bool
+	InputTokens  int
+	OutputTokens int
+}
+
+// newAnthropicStreamWriter 创建写出器并发送message_start事件
+func newAnthropicStreamWriter(pw *io.PipeWriter, model string) *anthropicStreamWriter {
+	w := &anthropicStreamWriter{pw: pw, index: -1}
+	messageStart := MessageStartEvent{
+		Type: "message_start",
+		Message: AnthropicResponse{
+			ID:           fmt.Sprintf("msg_%d", time.Now().UnixMilli()),
+			Type:         "message",
+			Role:         "assistant",
+			Content:      []AnthropicContent{},
+			Model:        model,
+			StopReason:   "",
+			StopSequence: nil,
+			Usage:        AnthropicUsage{InputTokens: 0, OutputTokens: 0},
+		},
+	}
+	sendSSEEvent(pw, "message_start", messageStart)
+	return w
+}
+
+// startBlock 关闭当前内容块并开始新的内容块
+func (w *anthropicStreamWriter) startBlock(blockType string, block interface{}) {
+	w.CloseBlock()
+	w.index++
+	w.blockType = blockType
+	sendSSEEvent(w.pw, "content_block_start", ContentBlockStartEvent{
+		Type:         "content_block_start",
+		Index:        w.index,
+		ContentBlock: block,
+	})
+}
+
+// delta 发送当前内容块的增量事件
+func (w *anthropicStreamWriter) delta(delta map[string]interface{}) {
+	sendSSEEvent(w.pw, "content_block_delta", ContentBlockDeltaEvent{
+		Type:  "content_block_delta",
+		Index: w.index,
+		Delta: delta,
+	})
+}
+
+// Text 写出文本增量，必要时开始新的text块
+func (w *anthropicStreamWriter) Text(text string) {
+	if text == "" {
+		return
+	}
+	if w.blockType != "text" {
+		w.startBlock("text", map[string]interface{}{"type": "text", "text": ""})
+	}
+	w.delta(map[string]interface{}{"type": "text_delta", "text": text})
+}
+
+// Thinking 写出思考增量，必要时开始新的thinking块
+func (w *anthropicStreamWriter) Thinking(thinking string) {
+	if thinking == "" {
+		return
+	}
+	if w.blockType != "thinking" {
+		w.startBlock("thinking", map[string]interface{}{"type": "thinking", "thinking": "", "signature": ""})
+	}
+	w.delta(map[string]interface{}{"type": "thinking_delta", "thinking": thinking})
+}
+
+// Signature 为思考块写出签名；当前不在thinking块中时写出一个只带签名的thinking块
+func (w *anthropicStreamWriter) Signature(signature string) {
+	if signature == "" {
+		return
+	}
+	if w.blockType != "thinking" {
+		w.startBlock("thinking", map[string]interface{}{"type": "thinking", "thinking": "", "signature": ""})
+	}
+	w.delta(map[string]interface{}{"type": "signature_delta", "signature": signature})
+	w.CloseBlock()
+}
+
+// StartToolUse 开始一个tool_use块
+func (w *anthropicStreamWriter) StartToolUse(id, name string) {
+	w.hasToolUse = true
+	w.startBlock("tool_use", AnthropicContent{
+		Type:  "tool_use",
+		ID:    id,
+		Name:  name,
+		Input: map[string]interface{}{},
+	})
+}
+
+// ToolInput 写出当前tool_use块的参数JSON片段
+func (w *anthropicStreamWriter) ToolInput(partialJSON string) {
+	if partialJSON == "" || w.blockType != "tool_use" {
+		return
+	}
+	w.delta(map[string]interface{}{"type": "input_json_delta", "partial_json": partialJSON})
+}
+
+// CloseBlock 关闭当前内容块
+func (w *anthropicStreamWriter) CloseBlock() {
+	if w.blockType == "" {
+		return
+	}
+	sendSSEEvent(w.pw, "content_block_stop", ContentBlockStopEvent{
+		Type:  "content_block_stop",
+		Index: w.index,
+	})
+	w.blockType = ""
+}
+
+// Finish 关闭内容块并发送message_delta和message_stop事件
+// stopReason为空时根据是否出现工具调用推断
+func (w *anthropicStreamWriter) Finish(stopReason string) {
+	w.CloseBlock()
+	if stopReason == "" {
+		stopReason = "end_turn"
+		if w.hasToolUse {
+			stopReason = "tool_use"
+		}
+	}
+	sendSSEEvent(w.pw, "message_delta", MessageDeltaEvent{
+		Type: "message_delta",
+		Delta: map[string]interface{}{
+			"stop_reason":   stopReason,
+			"stop_sequence": nil,
+		},
+		Usage: map[string]interface{}{
+			"input_tokens":  w.InputTokens,
+			"output_tokens": w.OutputTokens,
+		},
+	})
+	sendSSEEvent(w.pw, "message_stop", MessageStopEvent{Type: "message_stop"})
+}
+
+// Error 关闭内容块并发送error事件结束流，不再发送message_stop
+// 上游中途失败时客户端据此得知响应不完整，而不是把截断的内容当作正常结束
+func (w *anthropicStreamWriter) Error(message string) {
+	w.CloseBlock()
+	sendSSEEvent(w.pw, "error", map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    "api_error",
+			"message": message,
+		},
+	})
+}
+
+// generateToolUseID 为未提供ID的工具调用生成tool_use ID
+func generateToolUseID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("toolu_%d", time.Now().UnixNano())
+	}
+	return "toolu_" + hex.EncodeToString(b)
+}
+
+// readSSEData 逐条读取SSE流中的data字段，event为最近一次的event字段(可能为空)
+func readSSEData(r io.Reader, handle func(event, data string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	event := ""
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			event = ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data != "" && data != "[DONE]" {
+				handle(event, data)
+			}
+		}
+	}
+	return scanner.Err()
+}
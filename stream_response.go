@@ -62,7 +62,7 @@ type MessageStartEvent struct {
 type ContentBlockStartEvent struct {
 	Type         string            `json:"type"`
 	Index        int               `json:"index"`
-	ContentBlock AnthropicContent  `json:"content_block"`
+	ContentBlock interface{}       `json:"content_block"`
 }
 
 // ContentBlockDeltaEvent 内容块增量事件
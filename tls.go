@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TLSConfig 服务端TLS配置
+type TLSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	CertFile string `json:"cert_file,omitempty"` // PEM证书(可包含中间证书链)；与key_file都为空时使用自签名证书
+	KeyFile  string `json:"key_file,omitempty"`
+	// SelfSignedDir 自签名证书的缓存目录，默认certs
+	SelfSignedDir string `json:"self_signed_dir,omitempty"`
+	// SelfSignedHosts 自签名证书包含的域名和IP，默认localhost、127.0.0.1和::1
+	SelfSignedHosts []string `json:"self_signed_hosts,omitempty"`
+	// ClientCAFile 配置后启用mTLS，只接受由这些CA签发的客户端证书
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+	// ClientAuth require(默认)要求客户端证书；optional时没有证书也可以连接，提供的证书仍需通过校验
+	ClientAuth string `json:"client_auth,omitempty"`
+	// ReloadIntervalSeconds 检查证书文件变化的间隔，默认30秒
+	ReloadIntervalSeconds int `json:"reload_interval_seconds,omitempty"`
+}
+
+const (
+	selfSignedCertName = "selfsigned.crt"
+	selfSignedKeyName  = "selfsigned.key"
+	// selfSignedValidity 自签名证书有效期
+	selfSignedValidity = 365 * 24 * time.Hour
+	// selfSignedRenewBefore 自签名证书在到期前该时长内重新生成
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// certReloader 持有当前证书和客户端CA，文件变化时重新加载，新连接立即使用新证书
+type certReloader struct {
+	config    TLSConfig
+	certFile  string
+	keyFile   string
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// newTLSConfig 根据配置创建服务端TLS配置，并启动证书热加载
+func newTLSConfig(config TLSConfig) (*tls.Config, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("cert_file and key_file must be set together")
+	}
+	var clientAuth tls.ClientAuthType
+	switch config.ClientAuth {
+	case "", "require":
+		clientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("unknown client_auth %q", config.ClientAuth)
+	}
+
+	reloader := &certReloader{config: config, certFile: config.CertFile, keyFile: config.KeyFile}
+	if reloader.certFile == "" {
+		dir := config.SelfSignedDir
+		if dir == "" {
+			dir = "certs"
+		}
+		reloader.certFile = filepath.Join(dir, selfSignedCertName)
+		reloader.keyFile = filepath.Join(dir, selfSignedKeyName)
+		if err := reloader.ensureSelfSigned(); err != nil {
+			return nil, fmt.Errorf("self-signed certificate: %w", err)
+		}
+		log.Printf("Using self-signed certificate %s for %v", reloader.certFile, reloader.selfSignedHosts())
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if config.ClientCAFile != "" {
+		// 每个连接使用当前的客户端CA，CA文件更新后无需重启
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			reloader.mu.RLock()
+			defer reloader.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: reloader.getCertificate,
+				ClientAuth:     clientAuth,
+				ClientCAs:      reloader.clientCAs,
+				NextProtos:     []string{"h2", "http/1.1"},
+			}, nil
+		}
+	}
+
+	interval := time.Duration(config.ReloadIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go reloader.watch(interval)
+	return tlsConfig, nil
+}
+
+// getCertificate 返回当前证书
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// watchedFiles 返回需要监视变化的文件
+func (r *certReloader) watchedFiles() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// reload 加载证书和客户端CA，失败时保留原来的证书，文件再次变化后重试
+func (r *certReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.watchedFiles() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	r.mu.Lock()
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		data, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client CA: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", r.config.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.mu.Unlock()
+	return nil
+}
+
+// changed 判断证书文件是否有变化
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, file := range r.watchedFiles() {
+		info, err := os.Stat(file)
+		if err != nil {
+			// 文件正在被替换时可能暂时不存在，下次再检查
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch 定期检查证书文件，变化时重新加载；自签名证书临近到期时重新生成
+func (r *certReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if r.config.CertFile == "" {
+			if err := r.ensureSelfSigned(); err != nil {
+				log.Printf("Failed to renew self-signed certificate: %v", err)
+			}
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("Failed to reload TLS certificate, keeping the previous one: %v", err)
+			continue
+		}
+		log.Printf("Reloaded TLS certificate from %s", r.certFile)
+	}
+}
+
+// selfSignedHosts 返回自签名证书需要包含的域名和IP
+func (r *certReloader) selfSignedHosts() []string {
+	if len(r.config.SelfSignedHosts) > 0 {
+		return r.config.SelfSignedHosts
+	}
+	return []string{"localhost", "127.0.0.1", "::1"}
+}
+
+// ensureSelfSigned 缓存的自签名证书不存在、即将过期或不包含配置的主机时重新生成
+func (r *certReloader) ensureSelfSigned() error {
+	hosts := r.selfSignedHosts()
+	if cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile); err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Until(leaf.NotAfter) > selfSignedRenewBefore && coversHosts(leaf, hosts) {
+			return nil
+		}
+	}
+
+	certPEM, keyPEM, err := generateSelfSigned(hosts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.certFile), 0700); err != nil {
+		return err
+	}
+	// 先写证书再写私钥；两次写入之间进程退出留下不匹配的一对文件时，
+	// 下次启动或检查时上面的LoadX509KeyPair失败，重新生成而不是无法启动
+	if err := writeFileAtomic(r.certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	log.Printf("Generated self-signed certificate %s", r.certFile)
+	return nil
+}
+
+// coversHosts 判断证书是否包含所有主机
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			found := false
+			for _, certIP := range cert.IPAddresses {
+				if certIP.Equal(ip) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		} else if err := cert.VerifyHostname(host); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateSelfSigned 生成ECDSA P-256自签名证书，返回PEM编码的证书和私钥
+func generateSelfSigned(hosts []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "y-router-go self-signed", Organization: []string{"y-router-go"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		// 作为自己的CA，客户端可以直接把该证书加入信任列表
+		IsCA: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	var certPEM, keyPEM bytes.Buffer
+	if err := pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		return nil, nil, err
+	}
+	if err := pem.Encode(&keyPEM, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}); err != nil {
+		return nil, nil, err
+	}
+	return certPEM.Bytes(), keyPEM.Bytes(), nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestReloader 创建使用临时目录中自签名证书的certReloader
+func newTestReloader(t *testing.T, hosts []string) *certReloader {
+	t.Helper()
+	dir := t.TempDir()
+	return &certReloader{
+		config:   TLSConfig{SelfSignedHosts: hosts},
+		certFile: filepath.Join(dir, selfSignedCertName),
+		keyFile:  filepath.Join(dir, selfSignedKeyName),
+	}
+}
+
+// leafCertificate 解析证书文件中的第一张证书
+func leafCertificate(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestEnsureSelfSigned(t *testing.T) {
+	tests := []struct {
+		name           string
+		prepare        func(t *testing.T, r *certReloader)
+		hosts          []string
+		wantRegenerate bool
+	}{
+		{"valid certificate is kept", nil, nil, false},
+		{"hosts changed", nil, []string{"router.internal"}, true},
+		{"key does not match certificate", func(t *testing.T, r *certReloader) {
+			// 模拟写入证书后、写入私钥前进程退出
+			certPEM, _, err := generateSelfSigned(r.selfSignedHosts())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(r.certFile, certPEM, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, nil, true},
+		{"corrupt key file", func(t *testing.T, r *certReloader) {
+			if err := os.WriteFile(r.keyFile, []byte("garbage"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, nil, true},
+		{"missing certificate", func(t *testing.T, r *certReloader) {
+			if err := os.Remove(r.certFile); err != nil {
+				t.Fatal(err)
+			}
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReloader(t, nil)
+			if err := r.ensureSelfSigned(); err != nil {
+				t.Fatal(err)
+			}
+			serial := leafCertificate(t, r.certFile, r.keyFile).SerialNumber
+			if tt.prepare != nil {
+				tt.prepare(t, r)
+			}
+			r.config.SelfSignedHosts = tt.hosts
+
+			if err := r.ensureSelfSigned(); err != nil {
+				t.Fatal(err)
+			}
+			leaf := leafCertificate(t, r.certFile, r.keyFile)
+			if regenerated := leaf.SerialNumber.Cmp(serial) != 0; regenerated != tt.wantRegenerate {
+				t.Fatalf("regenerated = %v, want %v", regenerated, tt.wantRegenerate)
+			}
+			if !coversHosts(leaf, r.selfSignedHosts()) {
+				t.Fatalf("certificate does not cover %v", r.selfSignedHosts())
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigRecoversMismatchedSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, _, err := generateSelfSigned([]string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keyPEM, err := generateSelfSigned([]string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, selfSignedCertName), certPEM, 0644)
+	os.WriteFile(filepath.Join(dir, selfSignedKeyName), keyPEM, 0600)
+
+	config, err := newTLSConfig(TLSConfig{Enabled: true, SelfSignedDir: dir, ReloadIntervalSeconds: 3600})
+	if err != nil {
+		t.Fatalf("newTLSConfig: %v", err)
+	}
+	if cert, err := config.GetCertificate(nil); err != nil || cert == nil {
+		t.Fatalf("GetCertificate = %v, %v", cert, err)
+	}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	r := newTestReloader(t, nil)
+	if err := r.ensureSelfSigned(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	current := func() *tls.Certificate {
+		cert, _ := r.getCertificate(nil)
+		return cert
+	}
+	initial := current()
+
+	tests := []struct {
+		name        string
+		update      func(t *testing.T)
+		wantChanged bool
+		wantErr     bool
+		wantSwapped bool
+	}{
+		{"unchanged files", func(t *testing.T) {}, false, false, false},
+		{"broken certificate keeps the previous one", func(t *testing.T) {
+			if err := os.WriteFile(r.certFile, []byte("not a certificate"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, true, true, false},
+		{"broken file is not retried until it changes again", func(t *testing.T) {}, false, false, false},
+		{"new certificate is picked up", func(t *testing.T) {
+			r.config.SelfSignedHosts = []string{"localhost", "router.internal"}
+			if err := r.ensureSelfSigned(); err != nil {
+				t.Fatal(err)
+			}
+		}, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 保证修改时间与上次加载时不同
+			time.Sleep(10 * time.Millisecond)
+			tt.update(t)
+			if changed := r.changed(); changed != tt.wantChanged {
+				t.Fatalf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !tt.wantChanged {
+				return
+			}
+			if err := r.reload(); (err != nil) != tt.wantErr {
+				t.Fatalf("reload error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if swapped := current() != initial; swapped != tt.wantSwapped {
+				t.Fatalf("certificate swapped = %v, want %v", swapped, tt.wantSwapped)
+			}
+		})
+	}
+}
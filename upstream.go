@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Upstream 命名上游实例
+type Upstream struct {
+	Name     string
+	Config   UpstreamConfig
+	Provider Provider
+	Retry    retryPolicy
+	Keys     *keyPool // 服务端密钥池，未配置时为nil
+
+	breakersMu sync.Mutex
+	breakers   map[string]*circuitBreaker // 按模型名的熔断器
+}
+
+// legacyUpstreamName 由 openrouter_base_url/upstream 配置生成的默认上游名称
+const legacyUpstreamName = "default"
+
+// passthroughUpstreamName 由 passthrough 配置生成的上游名称
+const passthroughUpstreamName = "passthrough"
+
+var upstreams = make(map[string]*Upstream)
+var defaultUpstream *Upstream
+
+// routingMu 保护上游表、默认上游和模型规则；管理接口修改配置时整体替换，进行中的请求继续使用已解析的上游
+var routingMu sync.RWMutex
+
+// hasServerCredentials 判断上游是否配置了服务端凭据：api_key、key_pool、Azure AAD令牌或携带凭据的自定义请求头
+func (u *Upstream) hasServerCredentials() bool {
+	if u.Config.APIKey != "" || u.Keys != nil || u.Config.AADTokenFile != "" {
+		return true
+	}
+	for name, value := range u.Config.Headers {
+		if value != "" && isSecretHeader(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresAPIKey 判断上游是否需要API密钥，本地Ollama等适配器不使用密钥
+func (u *Upstream) requiresAPIKey() bool {
+	keyless, ok := u.Provider.(interface{ Keyless() bool })
+	return !ok || !keyless.Keyless()
+}
+
+// APIKeyFor 返回发往该上游的API密钥：配置了api_key时使用服务端密钥，否则转发客户端密钥
+// 配置了密钥池时每次尝试另行从池中选择
+func (u *Upstream) APIKeyFor(clientKey string) string {
+	if u.Config.APIKey != "" {
+		return u.Config.APIKey
+	}
+	return clientKey
+}
+
+// newUpstream 根据配置创建命名上游
+func newUpstream(name string, config UpstreamConfig) (*Upstream, error) {
+	config.APIKey = os.ExpandEnv(config.APIKey)
+	provider, err := newProvider(config)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %q: %w", name, err)
+	}
+	upstream := &Upstream{Name: name, Config: config, Provider: provider, Retry: newRetryPolicy(config.Retry)}
+	if config.KeyPool != nil {
+		upstream.Keys, err = newKeyPool(config.KeyPool)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q: %w", name, err)
+		}
+	}
+	return upstream, nil
+}
+
+// initUpstreams 根据配置创建所有上游
+// 需要在所有适配器类型通过init注册之后调用
+func initUpstreams() {
+	built, def, err := buildUpstreams(env.Upstreams, env.DefaultUpstream, nil)
+	if err != nil {
+		log.Fatalf("Failed to create upstream: %v", err)
+	}
+	upstreams = built
+	defaultUpstream = def
+
+	var names []string
+	for name := range upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		u := upstreams[name]
+		log.Printf("Upstream %s: %s at %s", name, u.Provider.Name(), u.Config.BaseURL)
+		if u.hasServerCredentials() && !env.AllowAnonymous && !env.VirtualKeys.Enabled {
+			log.Printf("Upstream %s uses server-side credentials; requests without a virtual key are rejected unless allow_anonymous is set", name)
+		}
+	}
+	if len(env.Passthrough.Models) > 0 {
+		log.Printf("Passthrough %v to %s", env.Passthrough.Models, env.Passthrough.BaseURL)
+	}
+	log.Printf("Default upstream: %s", defaultUpstream.Name)
+}
+
+// buildUpstreams 根据上游配置创建所有上游，返回上游表和默认上游；旧配置和透传配置取自env
+// previous中配置未变化的上游原样保留，重新加载时熔断器、密钥池状态和连接池不受影响
+func buildUpstreams(configs map[string]UpstreamConfig, defaultName string, previous map[string]*Upstream) (map[string]*Upstream, *Upstream, error) {
+	built := make(map[string]*Upstream)
+	add := func(name string, config UpstreamConfig) error {
+		if old, ok := previous[name]; ok && sameUpstreamConfig(old.Config, config) {
+			built[name] = old
+			return nil
+		}
+		upstream, err := newUpstream(name, config)
+		if err != nil {
+			return err
+		}
+		built[name] = upstream
+		return nil
+	}
+
+	// 兼容旧配置：openrouter_base_url + upstream 作为名为default的上游
+	if _, exists := configs[legacyUpstreamName]; !exists {
+		legacyConfig := env.Upstream
+		if legacyConfig.BaseURL == "" && (legacyConfig.Type == "" || legacyConfig.Type == "openai") {
+			legacyConfig.BaseURL = env.OpenRouterBaseUrl
+		}
+		if err := add(legacyUpstreamName, legacyConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for name, config := range configs {
+		if err := add(name, config); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(env.Passthrough.Models) > 0 {
+		passthroughConfig := env.Passthrough.UpstreamConfig
+		passthroughConfig.Type = "anthropic"
+		if err := add(passthroughUpstreamName, passthroughConfig); err != nil {
+			return nil, nil, fmt.Errorf("passthrough: %w", err)
+		}
+	}
+
+	if defaultName == "" {
+		defaultName = legacyUpstreamName
+	}
+	def, ok := built[defaultName]
+	if !ok {
+		return nil, nil, fmt.Errorf("default upstream %q is not defined", defaultName)
+	}
+	return built, def, nil
+}
+
+// closeReplacedUpstreams 关闭previous中未被current复用的上游的空闲连接
+// 每个上游有自己的连接池，被替换或删除后不再使用，不关闭的话空闲的keep-alive连接会一直保留到超时
+func closeReplacedUpstreams(previous, current map[string]*Upstream) {
+	for name, old := range previous {
+		if current[name] == old {
+			continue
+		}
+		if closer, ok := old.Provider.(idleConnectionCloser); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
+
+// sameUpstreamConfig 判断上游配置是否未变化，current是已展开环境变量的配置
+func sameUpstreamConfig(current, config UpstreamConfig) bool {
+	config.APIKey = os.ExpandEnv(config.APIKey)
+	return reflect.DeepEqual(current, config)
+}
+
+// upstreamByName 按名称查找上游
+func upstreamByName(name string) (*Upstream, bool) {
+	routingMu.RLock()
+	defer routingMu.RUnlock()
+	upstream, ok := upstreams[name]
+	return upstream, ok
+}
+
+// RouteTarget 路由目标：上游及发往该上游的模型名
+type RouteTarget struct {
+	Upstream     *Upstream
+	Model        string
+	PreferredKey string // 优先使用的密钥池密钥名称(会话亲和)
+}
+
+// Route 模型路由结果
+type Route struct {
+	Upstream  *Upstream
+	Model     string        // 发往上游的模型名
+	Fallbacks []RouteTarget // 主目标失败时依次尝试的目标
+	Hedge     *RouteHedge   // 对冲配置，未配置时为nil
+	Mirror    *RouteMirror  // 影子请求配置，未配置时为nil
+	// PreferredKey 主目标优先使用的密钥池密钥名称(会话亲和)
+	PreferredKey string
+	Match        *modelRuleMatch // 命中的映射规则，未命中时为nil
+	Passthrough  bool
+}
+
+// Targets 返回按尝试顺序排列的全部目标
+func (r Route) Targets() []RouteTarget {
+	targets := []RouteTarget{{Upstream: r.Upstream, Model: r.Model, PreferredKey: r.PreferredKey}}
+	return append(targets, r.Fallbacks...)
+}
+
+// splitUpstreamTarget 解析 "upstream:model" 形式的映射目标
+// 只有前缀是已定义的上游名称时才拆分，以免误拆 "qwen3:32b" 这类模型名
+func splitUpstreamTarget(target string) (*Upstream, string) {
+	routingMu.RLock()
+	defer routingMu.RUnlock()
+	return splitUpstreamTargetLocked(target)
+}
+
+// splitUpstreamTargetLocked 同splitUpstreamTarget，调用方需持有routingMu
+func splitUpstreamTargetLocked(target string) (*Upstream, string) {
+	if idx := strings.Index(target, ":"); idx > 0 {
+		if upstream, ok := upstreams[target[:idx]]; ok {
+			return upstream, target[idx+1:]
+		}
+	}
+	return defaultUpstream, target
+}
+
+// resolveRoute 根据模型名和请求特征选择上游，并返回映射后的上游模型名
+func resolveRoute(anthropicModel string, features *requestFeatures) Route {
+	routingMu.RLock()
+	defer routingMu.RUnlock()
+
+	if upstream, ok := upstreams[passthroughUpstreamName]; ok {
+		for _, keyword := range env.Passthrough.Models {
+			if strings.Contains(anthropicModel, keyword) {
+				route := Route{Upstream: upstream, Model: anthropicModel, Passthrough: true}
+				if match, ok := passthroughRules.Match(anthropicModel, features); ok {
+					route.Model = match.Target
+					route.Match = &match
+				}
+				return route
+			}
+		}
+	}
+
+	route := Route{Model: anthropicModel}
+	if match, ok := modelRules.Match(anthropicModel, features); ok {
+		route.Model = match.Target
+		route.Match = &match
+		route.Hedge = match.Hedge
+		route.Mirror = match.Mirror
+		for _, fallback := range match.Fallbacks {
+			var target RouteTarget
+			target.Upstream, target.Model = splitUpstreamTargetLocked(fallback)
+			route.Fallbacks = append(route.Fallbacks, target)
+		}
+	}
+	route.Upstream, route.Model = splitUpstreamTargetLocked(route.Model)
+	return route
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connTracker 记录测试服务端每个连接是否已关闭
+type connTracker struct {
+	mu     sync.Mutex
+	closed map[net.Conn]bool
+}
+
+func (c *connTracker) track(conn net.Conn, state http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		c.closed[conn] = false
+	case http.StateClosed, http.StateHijacked:
+		c.closed[conn] = true
+	}
+}
+
+// open 返回仍然打开的连接数
+func (c *connTracker) open() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, closed := range c.closed {
+		if !closed {
+			n++
+		}
+	}
+	return n
+}
+
+// idleRequest 通过上游的连接池发送一个请求并读完响应，连接回到空闲状态
+func idleRequest(t *testing.T, upstream *Upstream, url string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := upstream.Provider.(*openAIProvider).client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func TestCloseReplacedUpstreams(t *testing.T) {
+	tests := []struct {
+		name     string
+		next     func(url string) map[string]UpstreamConfig
+		wantOpen int
+	}{
+		{"unchanged upstream keeps its connections", func(url string) map[string]UpstreamConfig {
+			return map[string]UpstreamConfig{"a": {BaseURL: url}}
+		}, 1},
+		{"replaced upstream closes idle connections", func(url string) map[string]UpstreamConfig {
+			return map[string]UpstreamConfig{"a": {BaseURL: url, APIKey: "changed"}}
+		}, 0},
+		{"removed upstream closes idle connections", func(url string) map[string]UpstreamConfig {
+			return map[string]UpstreamConfig{"b": {BaseURL: url}}
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &connTracker{closed: make(map[net.Conn]bool)}
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.Config.ConnState = tracker.track
+			server.Start()
+			t.Cleanup(server.Close)
+
+			previous, _, err := buildUpstreams(map[string]UpstreamConfig{"a": {BaseURL: server.URL}}, "a", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			idleRequest(t, previous["a"], server.URL)
+
+			next := tt.next(server.URL)
+			var defaultName string
+			for name := range next {
+				defaultName = name
+			}
+			current, _, err := buildUpstreams(next, defaultName, previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			closeReplacedUpstreams(previous, current)
+
+			deadline := time.Now().Add(2 * time.Second)
+			for tracker.open() != tt.wantOpen && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if got := tracker.open(); got != tt.wantOpen {
+				t.Fatalf("open connections = %d, want %d", got, tt.wantOpen)
+			}
+		})
+	}
+}
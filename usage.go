@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// responseUsage 从非流式Anthropic响应中取出用量，转换后的结构体和透传的原始JSON都适用
+func responseUsage(anthropicResponse interface{}) AnthropicUsage {
+	if response, ok := anthropicResponse.(AnthropicResponse); ok {
+		return response.Usage
+	}
+	data, ok := anthropicResponse.(json.RawMessage)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(anthropicResponse); err != nil {
+			return AnthropicUsage{}
+		}
+	}
+	var response struct {
+		Usage AnthropicUsage `json:"usage"`
+	}
+	json.Unmarshal(data, &response)
+	return response.Usage
+}
+
+// usageMeter 在转发SSE流的同时从message_start和message_delta事件中读取用量
+type usageMeter struct {
+	inner   io.ReadCloser
+	pending []byte
+	Usage   AnthropicUsage
+}
+
+// newUsageMeter 包装Anthropic SSE流
+func newUsageMeter(stream io.ReadCloser) *usageMeter {
+	return &usageMeter{inner: stream}
+}
+
+func (m *usageMeter) Read(p []byte) (int, error) {
+	n, err := m.inner.Read(p)
+	if n > 0 {
+		m.pending = append(m.pending, p[:n]...)
+		m.scan()
+	}
+	return n, err
+}
+
+func (m *usageMeter) Close() error {
+	return m.inner.Close()
+}
+
+// scan 解析已收到的完整行，不完整的行留到下次读取
+func (m *usageMeter) scan() {
+	last := bytes.LastIndexByte(m.pending, '\n')
+	if last < 0 {
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(m.pending[:last+1]))
+	scanner.Buffer(make([]byte, 64*1024), len(m.pending))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		m.parseEvent(bytes.TrimSpace(line[len("data:"):]))
+	}
+	m.pending = append(m.pending[:0], m.pending[last+1:]...)
+}
+
+// parseEvent 记录事件中的用量；message_delta中的output_tokens是累计值
+func (m *usageMeter) parseEvent(data []byte) {
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	var event struct {
+		Type    string `json:"type"`
+		Message struct {
+			Usage *AnthropicUsage `json:"usage"`
+		} `json:"message"`
+		Usage *AnthropicUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return
+	}
+	switch event.Type {
+	case "message_start":
+		if event.Message.Usage != nil {
+			m.Usage = *event.Message.Usage
+		}
+	case "message_delta":
+		if event.Usage != nil {
+			if event.Usage.InputTokens > 0 {
+				m.Usage.InputTokens = event.Usage.InputTokens
+			}
+			if event.Usage.CacheCreationInputTokens > 0 {
+				m.Usage.CacheCreationInputTokens = event.Usage.CacheCreationInputTokens
+			}
+			if event.Usage.CacheReadInputTokens > 0 {
+				m.Usage.CacheReadInputTokens = event.Usage.CacheReadInputTokens
+			}
+			m.Usage.OutputTokens = event.Usage.OutputTokens
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// RuleVariant A/B分流的一个变体
+type RuleVariant struct {
+	Name   string `json:"name"`
+	Target string `json:"target"` // "upstream:model"，支持捕获组引用
+	Weight int    `json:"weight"`
+}
+
+// validateVariants 检查变体配置
+func validateVariants(variants []RuleVariant) error {
+	names := make(map[string]bool)
+	total := 0
+	for i, variant := range variants {
+		if variant.Name == "" {
+			return fmt.Errorf("variant %d: name is required", i)
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("variant %d: duplicate name %q", i, variant.Name)
+		}
+		names[variant.Name] = true
+		if variant.Target == "" {
+			return fmt.Errorf("variant %q: target is required", variant.Name)
+		}
+		if variant.Weight < 0 {
+			return fmt.Errorf("variant %q: weight must not be negative", variant.Name)
+		}
+		total += variant.Weight
+	}
+	if total == 0 {
+		return errors.New("variants must have a positive total weight")
+	}
+	return nil
+}
+
+// stickyKey 返回分流使用的粘性键：优先使用metadata.user_id，其次使用API密钥
+func (f *requestFeatures) stickyKey() string {
+	if f == nil {
+		return ""
+	}
+	if f.UserID != "" {
+		return "user:" + f.UserID
+	}
+	return "key:" + f.APIKey
+}
+
+// selectVariant 按权重选择变体；同一粘性键在同一规则下总是得到同一变体
+func selectVariant(rule *ModelRule, features *requestFeatures) RuleVariant {
+	total := 0
+	for _, variant := range rule.Variants {
+		total += variant.Weight
+	}
+
+	// 哈希中包含规则本身，使不同规则的分流相互独立
+	hash := fnv.New64a()
+	hash.Write([]byte(rule.Match + "\x00" + rule.Pattern + "\x00" + features.stickyKey()))
+	bucket := int(hash.Sum64() % uint64(total))
+
+	for _, variant := range rule.Variants {
+		if bucket < variant.Weight {
+			return variant
+		}
+		bucket -= variant.Weight
+	}
+	return rule.Variants[len(rule.Variants)-1]
+}
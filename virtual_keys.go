@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// virtualKeyPrefix 路由器签发的虚拟密钥前缀
+const virtualKeyPrefix = "sk-yr-"
+
+var (
+	errVirtualKeyInvalid = errors.New("invalid API key")
+	errVirtualKeyExpired = errors.New("API key has expired")
+	errVirtualKeyRevoked = errors.New("API key has been revoked")
+	errVirtualKeyUnknown = errors.New("virtual key not found")
+	// errVirtualKeyRequired 没有虚拟密钥的客户端不能使用服务端密钥，除非设置了allow_anonymous
+	errVirtualKeyRequired = errors.New("a virtual key is required to use this upstream")
+	// errNoUpstreamCredential 虚拟密钥没有为上游指定密钥，上游也没有服务端凭据
+	errNoUpstreamCredential = errors.New("no credential configured for this upstream")
+)
+
+// VirtualKeysConfig 虚拟密钥配置
+type VirtualKeysConfig struct {
+	Enabled bool   `json:"enabled"`
+	Store   string `json:"store,omitempty"` // 密钥存储文件，默认virtual_keys.json
+	// AllowClientKeys 为true时不在存储中的密钥按原方式转发给上游，便于逐步迁移
+	AllowClientKeys bool `json:"allow_client_keys,omitempty"`
+}
+
+// VirtualKey 路由器签发的虚拟密钥，存储中只保存其哈希
+type VirtualKey struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Owner  string `json:"owner,omitempty"`
+	Team   string `json:"team,omitempty"` // 所属团队，用于团队预算
+	Hash   string `json:"hash"`           // 密钥的SHA-256
+	Prefix string `json:"prefix"`         // 密钥开头几个字符，用于辨认
+	// Credentials 上游名称到真实密钥的映射，支持 ${ENV} 引用；未列出的上游使用其自身的api_key或key_pool
+	Credentials map[string]string `json:"credentials,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	RevokedAt   *time.Time        `json:"revoked_at,omitempty"`
+	// RateLimit 覆盖全局的rate_limits.per_key
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+	// Budget 覆盖全局的budgets.per_key
+	Budget *BudgetConfig `json:"budget,omitempty"`
+	// Policy 允许的模型和请求参数限制
+	Policy *KeyPolicy `json:"policy,omitempty"`
+}
+
+// VirtualKeyView 管理接口返回的虚拟密钥信息，不包含哈希和真实密钥
+type VirtualKeyView struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Owner     string           `json:"owner,omitempty"`
+	Team      string           `json:"team,omitempty"`
+	Prefix    string           `json:"prefix"`
+	Upstreams []string         `json:"credential_upstreams,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+	RevokedAt *time.Time       `json:"revoked_at,omitempty"`
+	Status    string           `json:"status"` // active、expired 或 revoked
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+	Budget    *BudgetConfig    `json:"budget,omitempty"`
+	Policy    *KeyPolicy       `json:"policy,omitempty"`
+}
+
+// virtualKeyStore 虚拟密钥存储
+type virtualKeyStore struct {
+	mu     sync.RWMutex
+	path   string
+	keys   []*VirtualKey
+	byHash map[string]*VirtualKey
+}
+
+var virtualKeys *virtualKeyStore
+
+// initVirtualKeys 加载虚拟密钥存储
+func initVirtualKeys() error {
+	if !env.VirtualKeys.Enabled {
+		return nil
+	}
+	path := env.VirtualKeys.Store
+	if path == "" {
+		path = "virtual_keys.json"
+	}
+	store, err := loadVirtualKeyStore(path)
+	if err != nil {
+		return err
+	}
+	virtualKeys = store
+	log.Printf("Loaded %d virtual keys from %s", len(store.keys), path)
+	return nil
+}
+
+// loadVirtualKeyStore 从文件加载虚拟密钥，文件不存在时创建空存储
+func loadVirtualKeyStore(path string) (*virtualKeyStore, error) {
+	store := &virtualKeyStore{path: path, byHash: make(map[string]*VirtualKey)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read virtual key store: %w", err)
+	}
+	if err := json.Unmarshal(data, &store.keys); err != nil {
+		return nil, fmt.Errorf("parse virtual key store %s: %w", path, err)
+	}
+	for _, key := range store.keys {
+		if key.Policy != nil {
+			if err := key.Policy.compile(); err != nil {
+				return nil, fmt.Errorf("virtual key %s: %w", key.ID, err)
+			}
+		}
+		store.byHash[key.Hash] = key
+	}
+	return store, nil
+}
+
+// hashVirtualKey 计算虚拟密钥的哈希
+func hashVirtualKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex 生成指定字节数的随机十六进制串
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// status 返回密钥当前状态
+func (k *VirtualKey) status(now time.Time) string {
+	switch {
+	case k.RevokedAt != nil:
+		return "revoked"
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return "expired"
+	}
+	return "active"
+}
+
+// View 返回不含敏感信息的密钥信息
+func (k *VirtualKey) View() VirtualKeyView {
+	view := VirtualKeyView{
+		ID:        k.ID,
+		Name:      k.Name,
+		Owner:     k.Owner,
+		Team:      k.Team,
+		Prefix:    k.Prefix,
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+		RevokedAt: k.RevokedAt,
+		Status:    k.status(time.Now()),
+		RateLimit: k.RateLimit,
+		Budget:    k.Budget,
+		Policy:    k.Policy,
+	}
+	for upstream := range k.Credentials {
+		view.Upstreams = append(view.Upstreams, upstream)
+	}
+	sort.Strings(view.Upstreams)
+	return view
+}
+
+// Authenticate 校验客户端提供的虚拟密钥
+func (s *virtualKeyStore) Authenticate(secret string) (*VirtualKey, error) {
+	if !strings.HasPrefix(secret, virtualKeyPrefix) {
+		return nil, errVirtualKeyInvalid
+	}
+	s.mu.RLock()
+	key, ok := s.byHash[hashVirtualKey(secret)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errVirtualKeyInvalid
+	}
+	switch key.status(time.Now()) {
+	case "revoked":
+		return nil, errVirtualKeyRevoked
+	case "expired":
+		return nil, errVirtualKeyExpired
+	}
+	return key, nil
+}
+
+// validate 检查密钥配置并编译策略；策略复制一份再编译，不修改仍在被请求使用的旧策略
+func (k *VirtualKey) validate() error {
+	if k.Name == "" {
+		return errors.New("name is required")
+	}
+	if k.Budget != nil {
+		if err := k.Budget.validate(); err != nil {
+			return err
+		}
+	}
+	if k.Policy != nil {
+		policy := *k.Policy
+		if err := policy.compile(); err != nil {
+			return err
+		}
+		k.Policy = &policy
+	}
+	for upstream := range k.Credentials {
+		if _, ok := upstreamByName(upstream); !ok {
+			return fmt.Errorf("unknown upstream %q in credentials", upstream)
+		}
+	}
+	return nil
+}
+
+// Create 签发新的虚拟密钥，返回只在此时可见的明文密钥
+func (s *virtualKeyStore) Create(key VirtualKey) (*VirtualKey, string, error) {
+	if err := key.validate(); err != nil {
+		return nil, "", err
+	}
+	random, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := randomHex(6)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := virtualKeyPrefix + random
+	key.ID = "vk_" + id
+	key.Hash = hashVirtualKey(secret)
+	key.Prefix = secret[:len(virtualKeyPrefix)+4]
+	key.CreatedAt = time.Now().UTC()
+	key.RevokedAt = nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, &key)
+	if err := s.saveLocked(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return nil, "", err
+	}
+	s.byHash[key.Hash] = &key
+	return &key, secret, nil
+}
+
+// Get 按ID查找虚拟密钥
+func (s *virtualKeyStore) Get(id string) (*VirtualKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Update 修改虚拟密钥并写回存储，返回修改前后的密钥
+// 修改作用在副本上再整体替换，进行中的请求继续使用旧的密钥配置
+func (s *virtualKeyStore) Update(id string, modify func(key *VirtualKey) error) (*VirtualKey, *VirtualKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, old := range s.keys {
+		if old.ID != id {
+			continue
+		}
+		updated := *old
+		if err := modify(&updated); err != nil {
+			return nil, nil, err
+		}
+		if err := updated.validate(); err != nil {
+			return nil, nil, err
+		}
+		updated.ID, updated.Hash, updated.Prefix, updated.CreatedAt = old.ID, old.Hash, old.Prefix, old.CreatedAt
+
+		s.keys[i] = &updated
+		if err := s.saveLocked(); err != nil {
+			s.keys[i] = old
+			return nil, nil, err
+		}
+		s.byHash[updated.Hash] = &updated
+		return old, &updated, nil
+	}
+	return nil, nil, errVirtualKeyUnknown
+}
+
+// Revoke 吊销虚拟密钥
+func (s *virtualKeyStore) Revoke(id string) (*VirtualKey, error) {
+	_, key, err := s.Update(id, func(key *VirtualKey) error {
+		if key.RevokedAt == nil {
+			now := time.Now().UTC()
+			key.RevokedAt = &now
+		}
+		return nil
+	})
+	return key, err
+}
+
+// List 返回所有虚拟密钥
+func (s *virtualKeyStore) List() []VirtualKeyView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	views := make([]VirtualKeyView, 0, len(s.keys))
+	for _, key := range s.keys {
+		views = append(views, key.View())
+	}
+	return views
+}
+
+// saveLocked 原子地写回存储文件，调用方需持有写锁
+func (s *virtualKeyStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免进程中断留下半个文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// clientCredentials 客户端身份：使用虚拟密钥时为对应的VirtualKey，否则为客户端自带的上游密钥
+type clientCredentials struct {
+	APIKey     string
+	VirtualKey *VirtualKey
+}
+
+// ID 返回用于分流和统计的客户端标识，不包含虚拟密钥明文
+func (c clientCredentials) ID() string {
+	if c.VirtualKey != nil {
+		return c.VirtualKey.ID
+	}
+	return c.APIKey
+}
+
+// forUpstream 返回发往上游的密钥，以及是否使用上游的密钥池
+// 虚拟密钥为该上游指定了真实密钥时使用该密钥；否则使用上游的api_key或key_pool，从不转发虚拟密钥本身。
+// 没有虚拟密钥的客户端只能使用自己的密钥，上游配置了服务端密钥时返回errVirtualKeyRequired，
+// 否则任何人携带任意令牌都能消耗服务端密钥；allow_anonymous为true时放行。
+// 虚拟密钥和上游都没有可用的凭据时返回errNoUpstreamCredential，而不是发出空密钥得到上游的401
+func (c clientCredentials) forUpstream(u *Upstream) (string, bool, error) {
+	if c.VirtualKey == nil {
+		if u.hasServerCredentials() && !env.AllowAnonymous {
+			return "", false, errVirtualKeyRequired
+		}
+		return u.APIKeyFor(c.APIKey), true, nil
+	}
+	if credential, ok := c.VirtualKey.Credentials[u.Name]; ok {
+		return os.ExpandEnv(credential), false, nil
+	}
+	if !u.hasServerCredentials() && u.requiresAPIKey() {
+		return "", false, fmt.Errorf("%w: virtual key %s has no credentials entry for upstream %q and the upstream has no api_key or key_pool",
+			errNoUpstreamCredential, c.VirtualKey.ID, u.Name)
+	}
+	return u.Config.APIKey, true, nil
+}